@@ -0,0 +1,252 @@
+package apidocs
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"GOLANG_SERVER/components/events"
+	"GOLANG_SERVER/components/health"
+	"GOLANG_SERVER/components/ingest"
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/protocal/rest"
+	"GOLANG_SERVER/components/replay"
+	"GOLANG_SERVER/components/response"
+	"GOLANG_SERVER/components/router"
+	"GOLANG_SERVER/components/schema"
+	"GOLANG_SERVER/components/user"
+	"GOLANG_SERVER/components/validate"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed openapi.yaml
+var document []byte
+
+//go:embed index.html
+var page []byte
+
+// * Swagger UI is served from the binary, the docs don't depend on a CDN
+//
+//go:generate sh fetch-swagger-ui.sh
+//go:embed swagger-ui
+var swaggerUI embed.FS
+
+var logger = logging.For("apidocs")
+
+// models are the types sent and received by the API; their schemas are generated from the
+// json tags, together with the schemas of the types they contain
+var models = []any{
+	schema.GyroData{},
+	schema.QuarantineRecord{},
+	schema.PasswordRequest{},
+	schema.Organization{},
+	schema.AuditEntry{},
+	user.Profile{},
+	rest.BulkResponse{},
+	ingest.ImportReport{},
+	events.Status{},
+	events.Alert{},
+	replay.Request{},
+	replay.Control{},
+	replay.State{},
+	validate.Rules{},
+	response.Envelope{},
+	health.Component{},
+	health.Job{},
+}
+
+var methods = []string{"get", "put", "post", "delete", "patch", "head", "options"}
+
+// * the OpenAPI document as served, built by Setup
+var spec []byte
+
+// Setup builds the OpenAPI document for the registered routes. It fails if a route has no
+// operation in openapi.yaml or an operation has no route, so the document can't drift from the handlers.
+// The deprecated aliases are added as copies of their successor.
+func Setup(routes []router.Route) error {
+	var doc map[string]any
+	if err := yaml.Unmarshal(document, &doc); err != nil {
+		return fmt.Errorf("openapi.yaml: %w", err)
+	}
+	paths, _ := doc["paths"].(map[string]any)
+	components, _ := doc["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+	if paths == nil || schemas == nil {
+		return errors.New("openapi.yaml: paths and components.schemas are required")
+	}
+
+	if err := checkRoutes(paths, routes); err != nil {
+		return err
+	}
+	for _, route := range routes {
+		if route.Deprecated {
+			addAlias(paths, route)
+		}
+	}
+
+	generator := newGenerator(schemas)
+	for _, model := range models {
+		if _, err := generator.schemaOf(reflect.TypeOf(model)); err != nil {
+			return err
+		}
+	}
+
+	if err := checkRefs(doc, doc); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	spec = encoded
+
+	if _, err := fs.Stat(swaggerUI, "swagger-ui/swagger-ui-bundle.js"); err != nil {
+		logger.Warn("Swagger UI is not vendored, /api/docs stays blank; run go generate ./components/apidocs")
+	}
+	return nil
+}
+
+// * the interactive documentation, Swagger UI reading the document below
+func HandleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+// * the files of Swagger UI
+func HandleAssets(w http.ResponseWriter, r *http.Request) {
+	http.ServeFileFS(w, r, swaggerUI, "swagger-ui/"+r.PathValue("file"))
+}
+
+// * the OpenAPI document
+func HandleSpec(w http.ResponseWriter, r *http.Request) {
+	if spec == nil {
+		response.Error(w, http.StatusServiceUnavailable, response.CodeInternal, "API documentation is not set up")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// checkRoutes compares the registered routes with the operations of the document
+func checkRoutes(paths map[string]any, routes []router.Route) error {
+	var problems []error
+	registered := make(map[string]bool)
+	for _, route := range routes {
+		if route.Deprecated {
+			continue
+		}
+		key := strings.ToLower(route.Method) + " " + pathOf(route.Path)
+		registered[key] = true
+		if operationOf(paths, key) == nil {
+			problems = append(problems, fmt.Errorf("route %s %s is not documented", route.Method, route.Path))
+		}
+	}
+
+	for _, path := range sortedKeys(paths) {
+		item, _ := paths[path].(map[string]any)
+		for _, method := range methods {
+			if _, ok := item[method]; ok && !registered[method+" "+path] {
+				problems = append(problems, fmt.Errorf("documented %s %s has no route", strings.ToUpper(method), path))
+			}
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// addAlias documents a deprecated path as its successor, marked deprecated
+func addAlias(paths map[string]any, route router.Route) {
+	method := strings.ToLower(route.Method)
+	successor, _ := paths[pathOf(route.Successor)].(map[string]any)
+	operation := operationOf(paths, method+" "+pathOf(route.Successor))
+	if operation == nil {
+		return
+	}
+
+	alias := make(map[string]any, len(operation)+1)
+	for key, value := range operation {
+		alias[key] = value
+	}
+	alias["deprecated"] = true
+	alias["description"] = "Deprecated, use " + route.Successor + "."
+
+	path := pathOf(route.Path)
+	item, _ := paths[path].(map[string]any)
+	if item == nil {
+		item = make(map[string]any)
+		if parameters, ok := successor["parameters"]; ok {
+			item["parameters"] = parameters
+		}
+		paths[path] = item
+	}
+	item[method] = alias
+}
+
+// checkRefs reports every $ref that doesn't point into the document
+func checkRefs(doc map[string]any, node any) error {
+	var problems []error
+	switch value := node.(type) {
+	case map[string]any:
+		for _, key := range sortedKeys(value) {
+			if ref, ok := value[key].(string); ok && key == "$ref" {
+				if resolve(doc, ref) == nil {
+					problems = append(problems, fmt.Errorf("unresolved $ref %q", ref))
+				}
+				continue
+			}
+			problems = append(problems, checkRefs(doc, value[key]))
+		}
+	case []any:
+		for _, item := range value {
+			problems = append(problems, checkRefs(doc, item))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// resolve follows a local reference such as "#/components/schemas/GyroData"
+func resolve(doc map[string]any, ref string) any {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var node any = doc
+	for _, name := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		object, ok := node.(map[string]any)
+		if !ok {
+			return nil
+		}
+		if node, ok = object[name]; !ok {
+			return nil
+		}
+	}
+	return node
+}
+
+func operationOf(paths map[string]any, key string) map[string]any {
+	method, path, _ := strings.Cut(key, " ")
+	item, _ := paths[path].(map[string]any)
+	operation, _ := item[method].(map[string]any)
+	return operation
+}
+
+// pathOf turns a route pattern into an OpenAPI path: "/api/v1/{$}" is "/api/v1/", "{name...}" is "{name}"
+func pathOf(pattern string) string {
+	pattern = strings.TrimSuffix(pattern, "{$}")
+	return strings.ReplaceAll(pattern, "...}", "}")
+}
+
+func sortedKeys(object map[string]any) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,50 @@
+package apidocs
+
+import (
+	"strings"
+	"testing"
+
+	"GOLANG_SERVER/components/router"
+)
+
+func TestCheckRoutes(t *testing.T) {
+	paths := map[string]any{
+		"/api/v1/data/{device}": map[string]any{"get": map[string]any{}},
+		"/api/v1/gone":          map[string]any{"post": map[string]any{}},
+	}
+	routes := []router.Route{
+		{Method: "GET", Path: "/api/v1/data/{device...}"},
+		{Method: "PUT", Path: "/api/v1/rules"},
+		{Method: "GET", Path: "/data/{device}", Deprecated: true, Successor: "/api/v1/data/{device}"},
+	}
+
+	err := checkRoutes(paths, routes)
+	if err == nil {
+		t.Fatal("undocumented and unrouted operations were accepted")
+	}
+	for _, want := range []string{"route PUT /api/v1/rules is not documented", "documented POST /api/v1/gone has no route"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "device") {
+		t.Errorf("error = %v, the documented route and its alias are fine", err)
+	}
+}
+
+func TestCheckRefs(t *testing.T) {
+	doc := map[string]any{
+		"components": map[string]any{"schemas": map[string]any{"GyroData": map[string]any{}}},
+		"paths": map[string]any{
+			"/a": map[string]any{"$ref": "#/components/schemas/GyroData"},
+			"/b": []any{map[string]any{"$ref": "#/components/schemas/Missing"}},
+			"/c": map[string]any{"$ref": "https://example.com/schema"},
+		},
+	}
+
+	err := checkRefs(doc, doc)
+	if err == nil || strings.Contains(err.Error(), "GyroData") ||
+		!strings.Contains(err.Error(), "Missing") || !strings.Contains(err.Error(), "example.com") {
+		t.Fatalf("error = %v, want the missing and the remote $ref", err)
+	}
+}
@@ -0,0 +1,14 @@
+#!/bin/sh
+# Vendors the Swagger UI files served by /api/docs into swagger-ui/, at the version in swagger-ui/VERSION.
+# npm checks the package against the integrity hash of the registry. Run through `go generate`.
+set -eu
+cd "$(dirname "$0")"
+version=$(cat swagger-ui/VERSION)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+npm pack --silent --pack-destination "$work" "swagger-ui-dist@$version" >/dev/null
+tar -xzf "$work/swagger-ui-dist-$version.tgz" -C "$work"
+for file in swagger-ui.css swagger-ui-bundle.js LICENSE; do
+	cp "$work/package/$file" swagger-ui/
+done
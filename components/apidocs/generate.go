@@ -0,0 +1,127 @@
+package apidocs
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// generator adds the schemas of Go types to components.schemas, named after the type
+type generator struct {
+	schemas   map[string]any
+	generated map[string]reflect.Type
+}
+
+func newGenerator(schemas map[string]any) *generator {
+	return &generator{schemas: schemas, generated: make(map[string]reflect.Type)}
+}
+
+// schemaOf returns the schema of a type as encoding/json writes it; named structs become a $ref
+func (g *generator) schemaOf(t reflect.Type) (map[string]any, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}, nil
+	case objectIDType:
+		return map[string]any{"type": "string", "pattern": "^[0-9a-f]{24}$"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}, nil
+	case reflect.Float32:
+		return map[string]any{"type": "number", "format": "float"}, nil
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}, nil
+	case reflect.Interface:
+		return map[string]any{}, nil
+	case reflect.Slice, reflect.Array:
+		// * encoding/json writes []byte as base64
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}, nil
+		}
+		items, err := g.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		values, err := g.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		return g.named(t)
+	}
+	return nil, fmt.Errorf("no schema for %s", t)
+}
+
+// named generates the schema of a named struct once and refers to it
+func (g *generator) named(t reflect.Type) (map[string]any, error) {
+	name := t.Name()
+	ref := map[string]any{"$ref": "#/components/schemas/" + name}
+	if existing, ok := g.generated[name]; ok {
+		if existing != t {
+			return nil, fmt.Errorf("schema %s: both %s and %s", name, existing, t)
+		}
+		return ref, nil
+	}
+	if _, ok := g.schemas[name]; ok {
+		return nil, fmt.Errorf("schema %s of %s is also written in openapi.yaml", name, t)
+	}
+
+	// * registered before the fields, so a type containing itself refers to itself
+	g.generated[name] = t
+	object, err := g.object(t)
+	if err != nil {
+		return nil, err
+	}
+	g.schemas[name] = object
+	return ref, nil
+}
+
+// object lists the fields of a struct under their json names, leaving out "-" and unexported fields
+func (g *generator) object(t reflect.Type) (map[string]any, error) {
+	properties := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property, err := g.schemaOf(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), field.Name, err)
+		}
+		properties[name] = property
+	}
+	return map[string]any{"type": "object", "properties": properties}, nil
+}
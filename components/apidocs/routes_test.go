@@ -0,0 +1,14 @@
+package apidocs_test
+
+import (
+	"testing"
+
+	"GOLANG_SERVER/components/apidocs"
+	"GOLANG_SERVER/components/routes"
+)
+
+func TestDocumentMatchesRoutes(t *testing.T) {
+	if err := apidocs.Setup(routes.API().Routes()); err != nil {
+		t.Fatal(err)
+	}
+}
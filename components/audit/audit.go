@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"net/http"
+
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/ratelimit"
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin            = "user.login"
+	ActionLogout           = "user.logout"
+	ActionRegister         = "user.register"
+	ActionPasswordReset    = "user.password.reset"
+	ActionPasswordChange   = "user.password.change"
+	ActionEmailChange      = "user.email"
+	ActionUserDisable      = "user.disable"
+	ActionUserEnable       = "user.enable"
+	ActionUserDelete       = "user.delete"
+	ActionRoleChange       = "user.role"
+	ActionOrganizationMove = "user.organization"
+	ActionSSOAllow         = "user.sso"
+	ActionLockout          = "account.lockout"
+	ActionTwoFactorEnable  = "2fa.enable"
+	ActionTwoFactorDisable = "2fa.disable"
+	ActionTwoFactorRoles   = "organization.2fa"
+	ActionOrganizationAdd  = "organization.create"
+	ActionDeviceRegister   = "device.register"
+	ActionRulesUpdate      = "rules.update"
+	ActionDataClean        = "data.clean"
+	ActionDataImport       = "data.import"
+	ActionQuarantineReplay = "quarantine.replay"
+)
+
+var logger = logging.For("audit")
+
+// Outcomes of an audited action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// Record appends an entry to the audit log. Failing to write it is logged but never fails the request.
+// The entry belongs to the organization of the logged-in user or, on open endpoints such as
+// /login, to the organization of the actor's account.
+func Record(r *http.Request, actor string, action string, target string, outcome string) {
+	entry := schema.AuditEntry{
+		Actor:        actor,
+		IP:           ratelimit.ClientIP(r),
+		Action:       action,
+		Target:       target,
+		Outcome:      outcome,
+		Organization: auth.Organization(r),
+	}
+	if entry.Organization == "" {
+		if user, err := db.GetUserByEmail(actor); err == nil {
+			entry.Organization = user.Organization
+		}
+	}
+	if _, err := db.StoreAudit(entry); err != nil {
+		logger.ErrorContext(r.Context(), "Error writing audit entry", "action", action, "error", err)
+	}
+}
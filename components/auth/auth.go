@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/response"
+	schema "GOLANG_SERVER/components/schema"
+)
+
+type contextKey struct{}
+
+// * privilege level of each role, a role may do everything a lower one can
+var levels = map[string]int{
+	schema.RoleViewer:   1,
+	schema.RoleEngineer: 2,
+	schema.RoleAdmin:    3,
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := levels[role]
+	return ok
+}
+
+// RoleOf returns the role of a user; accounts created before roles existed are viewers
+func RoleOf(user schema.User) string {
+	if ValidRole(user.Role) {
+		return user.Role
+	}
+	return schema.RoleViewer
+}
+
+// HasRole reports whether the user's role is at least the required one
+func HasRole(user schema.User, required string) bool {
+	return levels[RoleOf(user)] >= levels[required]
+}
+
+// Token returns the bearer token of a request. Browsers can't set headers on
+// WebSocket connections, so the token may also be passed as ?token=.
+func Token(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Require only lets requests through whose session belongs to a user with at least the given role
+func Require(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := authenticate(w, r)
+		if !ok {
+			return
+		}
+		if !HasRole(user, role) {
+			response.Error(w, http.StatusForbidden, response.CodeForbidden, "Forbidden")
+			return
+		}
+		// * new accounts see nothing until an admin adds them to an organization
+		if user.Organization == "" {
+			response.Error(w, http.StatusForbidden, response.CodeForbidden, "Not a member of any organization")
+			return
+		}
+		if required, err := TwoFactorRequired(user); err != nil {
+			response.Err(w, r, err)
+			return
+		} else if required && !user.TOTPEnabled {
+			response.Error(w, http.StatusForbidden, response.CodeTwoFactorRequired, "Two-factor authentication must be set up")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, user)))
+	}
+}
+
+// Authenticated lets any logged-in user through, whatever their role, organization or
+// two-factor setup; it guards the endpoints users need to complete their own account
+func Authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := authenticate(w, r)
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, user)))
+	}
+}
+
+// TwoFactorRequired reports whether the organization of the user requires two-factor authentication for their role
+func TwoFactorRequired(user schema.User) (bool, error) {
+	// * single sign-on users get their second factor from the identity provider
+	if user.Organization == "" || user.Provider == schema.ProviderOIDC {
+		return false, nil
+	}
+	organization, err := db.GetOrganization(user.Organization)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(organization.TwoFactorRoles, RoleOf(user)), nil
+}
+
+// authenticate loads the user of the request's session, answering 401 itself when there is none
+func authenticate(w http.ResponseWriter, r *http.Request) (schema.User, bool) {
+	token := Token(r)
+	if token == "" {
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Authentication required")
+		return schema.User{}, false
+	}
+
+	session, err := db.GetSession(token)
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Invalid or expired session")
+		return schema.User{}, false
+	}
+
+	// * the user is loaded on every request so role changes apply immediately
+	user, err := db.GetUserByEmail(session.Email)
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Invalid or expired session")
+		return schema.User{}, false
+	}
+	if user.Disabled {
+		response.Error(w, http.StatusUnauthorized, response.CodeAccountDisabled, "Account disabled")
+		return schema.User{}, false
+	}
+	return user, true
+}
+
+// UserFrom returns the user authenticated by Require
+func UserFrom(r *http.Request) (schema.User, bool) {
+	user, ok := r.Context().Value(contextKey{}).(schema.User)
+	return user, ok
+}
+
+// Organization returns the organization of the user authenticated by Require, every query is scoped to it
+func Organization(r *http.Request) string {
+	user, _ := UserFrom(r)
+	return user.Organization
+}
+
+// IsOperator reports whether the user administers the server itself, i.e. is an admin of the default organization
+func IsOperator(user schema.User) bool {
+	return user.Organization == schema.DefaultOrganization && HasRole(user, schema.RoleAdmin)
+}
@@ -0,0 +1,169 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	schema "GOLANG_SERVER/components/schema"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Payload encodings accepted by the ingestion paths
+const (
+	FormatJSON   = "json"
+	FormatCBOR   = "cbor"
+	FormatPacked = "packed"
+)
+
+// Content types selecting a binary format on REST requests
+const (
+	ContentTypeCBOR   = "application/cbor"
+	ContentTypePacked = "application/vnd.gyro.packed"
+)
+
+// PackedVersion is the only layout version of the packed format so far
+const PackedVersion = 1
+
+// packedV1 is the fixed little-endian layout sent by constrained gateways (118 bytes):
+// version, flags (bit 0 = ModbusHighSpeed), sequence, device timestamp in ms, temperature,
+// the 7 float32 fields of X, Y and Z in GyroStruct order and the NUL-padded device address.
+type packedV1 struct {
+	Version         uint8
+	Flags           uint8
+	Sequence        uint32
+	DeviceTimeStamp int64
+	Temperature     float32
+	X               schema.GyroStruct
+	Y               schema.GyroStruct
+	Z               schema.GyroStruct
+	DeviceAddress   [16]byte
+}
+
+// PackedSize is the length in bytes of a version 1 packed reading
+var PackedSize = binary.Size(packedV1{})
+
+// Decode decodes a reading from one of the binary formats. strict rejects CBOR keys that are not GyroData fields.
+// JSON readings are decoded by the validate package, which also checks for required keys.
+func Decode(format string, raw []byte, strict bool) (schema.GyroData, error) {
+	var data schema.GyroData
+
+	switch format {
+	case FormatCBOR:
+		options := cbor.DecOptions{}
+		if strict {
+			options.ExtraReturnErrors = cbor.ExtraDecErrorUnknownField
+		}
+		mode, err := options.DecMode()
+		if err != nil {
+			return schema.GyroData{}, err
+		}
+		if err := mode.Unmarshal(raw, &data); err != nil {
+			return schema.GyroData{}, err
+		}
+	case FormatPacked:
+		return decodePacked(raw)
+	default:
+		return schema.GyroData{}, fmt.Errorf("unknown payload format %q", format)
+	}
+	return data, nil
+}
+
+// EncodePacked encodes a reading in the packed layout, e.g. for simulators and tools
+func EncodePacked(data schema.GyroData) ([]byte, error) {
+	if len(data.DeviceAddress) > 16 {
+		return nil, errors.New("device address longer than 16 bytes")
+	}
+
+	packed := packedV1{
+		Version:         PackedVersion,
+		DeviceTimeStamp: data.DeviceTimeStamp,
+		Temperature:     data.Temperature,
+		X:               data.X,
+		Y:               data.Y,
+		Z:               data.Z,
+	}
+	if data.Sequence != nil {
+		packed.Sequence = uint32(*data.Sequence)
+	}
+	if data.ModbusHighSpeed {
+		packed.Flags |= 1
+	}
+	copy(packed.DeviceAddress[:], data.DeviceAddress)
+
+	var buffer bytes.Buffer
+	if err := binary.Write(&buffer, binary.LittleEndian, packed); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodePacked(raw []byte) (schema.GyroData, error) {
+	if len(raw) == 0 {
+		return schema.GyroData{}, errors.New("empty packed payload")
+	}
+	if raw[0] != PackedVersion {
+		return schema.GyroData{}, fmt.Errorf("unsupported packed payload version %d", raw[0])
+	}
+	if len(raw) != PackedSize {
+		return schema.GyroData{}, fmt.Errorf("packed payload is %d bytes, expected %d", len(raw), PackedSize)
+	}
+
+	var packed packedV1
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &packed); err != nil {
+		return schema.GyroData{}, err
+	}
+
+	// * the layout has no boot id, the sequence is kept but recognises no duplicates; the device timestamp does
+	sequence := int64(packed.Sequence)
+	return schema.GyroData{
+		DeviceAddress:   string(bytes.TrimRight(packed.DeviceAddress[:], "\x00")),
+		X:               packed.X,
+		Y:               packed.Y,
+		Z:               packed.Z,
+		Temperature:     packed.Temperature,
+		ModbusHighSpeed: packed.Flags&1 != 0,
+		Sequence:        &sequence,
+		DeviceTimeStamp: packed.DeviceTimeStamp,
+	}, nil
+}
+
+// FormatFromContentType selects the format from a REST Content-Type header, defaulting to JSON
+func FormatFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return FormatJSON
+	}
+	switch mediaType {
+	case ContentTypeCBOR:
+		return FormatCBOR
+	case ContentTypePacked, "application/octet-stream":
+		return FormatPacked
+	default:
+		return FormatJSON
+	}
+}
+
+// FormatFromTopic selects the format from an MQTT topic suffix: "sample/cbor", "sample/packed", else JSON
+func FormatFromTopic(topic string) string {
+	switch topic[strings.LastIndex(topic, "/")+1:] {
+	case FormatCBOR:
+		return FormatCBOR
+	case FormatPacked:
+		return FormatPacked
+	default:
+		return FormatJSON
+	}
+}
+
+// Sniff guesses the format of a binary WebSocket frame from its first byte
+func Sniff(raw []byte) string {
+	if len(raw) > 0 && raw[0] == PackedVersion {
+		return FormatPacked
+	}
+	return FormatCBOR
+}
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"GOLANG_SERVER/components/env"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the whole server configuration. Every setting can come from, in order of precedence:
+// an environment variable (the env tag), a .env file, the YAML or TOML file named by CONFIG_FILE,
+// or its default.
+type Config struct {
+	Port    int    `yaml:"port" toml:"port" env:"PORT" default:"8000"`
+	Message string `yaml:"message" toml:"message" env:"MESSAGE"`
+	// Port serving /metrics to Prometheus, apart from the public API; 0 turns it off
+	MetricsPort int `yaml:"metrics_port" toml:"metrics_port" env:"METRICS_PORT" default:"9464"`
+	// Operator password, also needed to /clean the default organization; tenants confirm with their own
+	CleanPassword string `yaml:"clean_password" toml:"clean_password" env:"PASSWORD" secret:"true"`
+	// Trust X-Forwarded-For, only behind a reverse proxy
+	TrustProxy bool `yaml:"trust_proxy" toml:"trust_proxy" env:"TRUST_PROXY"`
+	// Origins of browser apps allowed to call the API, comma separated, * for any
+	CORSOrigins string `yaml:"cors_origins" toml:"cors_origins" env:"CORS_ORIGINS"`
+
+	Mongo  Mongo  `yaml:"mongo" toml:"mongo"`
+	MQTT   MQTT   `yaml:"mqtt" toml:"mqtt"`
+	SMTP   SMTP   `yaml:"smtp" toml:"smtp"`
+	Auth   Auth   `yaml:"auth" toml:"auth"`
+	OIDC   OIDC   `yaml:"oidc" toml:"oidc"`
+	Ingest Ingest `yaml:"ingest" toml:"ingest"`
+	Modbus Modbus `yaml:"modbus" toml:"modbus"`
+	Log    Log    `yaml:"log" toml:"log"`
+}
+
+type Mongo struct {
+	URI      string `yaml:"uri" toml:"uri" env:"MONGO_URI" secret:"true"`
+	Database string `yaml:"database" toml:"database" env:"MONGO_DB"`
+
+	Collection              string `yaml:"collection" toml:"collection" env:"MONGO_COLLECTION"`
+	UserCollection          string `yaml:"user_collection" toml:"user_collection" env:"MONGO_USERCOLLECTION"`
+	DeviceCollection        string `yaml:"device_collection" toml:"device_collection" env:"MONGO_DEVICECOLLECTION"`
+	QuarantineCollection    string `yaml:"quarantine_collection" toml:"quarantine_collection" env:"MONGO_QUARANTINECOLLECTION" default:"quarantine"`
+	SessionCollection       string `yaml:"session_collection" toml:"session_collection" env:"MONGO_SESSIONCOLLECTION" default:"sessions"`
+	PasswordResetCollection string `yaml:"password_reset_collection" toml:"password_reset_collection" env:"MONGO_PASSWORDRESETCOLLECTION" default:"passwordresets"`
+	OrganizationCollection  string `yaml:"organization_collection" toml:"organization_collection" env:"MONGO_ORGANIZATIONCOLLECTION" default:"organizations"`
+	AuditCollection         string `yaml:"audit_collection" toml:"audit_collection" env:"MONGO_AUDITCOLLECTION" default:"audit"`
+}
+
+type MQTT struct {
+	Broker   string `yaml:"broker" toml:"broker" env:"MQTT_BROKER"`
+	ClientID string `yaml:"client_id" toml:"client_id" env:"MQTT_CLIENT_ID" default:"go_mqtt_client"`
+	Username string `yaml:"username" toml:"username" env:"MQTT_USERNAME"`
+	Password string `yaml:"password" toml:"password" env:"MQTT_PASSWORD" secret:"true"`
+}
+
+type SMTP struct {
+	Host     string `yaml:"host" toml:"host" env:"SMTP_HOST" default:"smtp.gmail.com"`
+	Port     int    `yaml:"port" toml:"port" env:"SMTP_PORT" default:"587"`
+	Username string `yaml:"username" toml:"username" env:"SMTP_USERNAME"`
+	Password string `yaml:"password" toml:"password" env:"SMTP_PASSWORD" secret:"true"`
+	// Sender address, the username if empty
+	From string `yaml:"from" toml:"from" env:"SMTP_FROM"`
+}
+
+type Auth struct {
+	SessionTTL time.Duration `yaml:"session_ttl" toml:"session_ttl" env:"SESSION_TTL" default:"24h"`
+	// false leaves single sign-on as the only way to log in
+	PasswordLogin bool `yaml:"password_login" toml:"password_login" env:"PASSWORD_LOGIN" default:"true"`
+	// The frontend's reset page the emailed links point at
+	PasswordResetURL string `yaml:"password_reset_url" toml:"password_reset_url" env:"PASSWORD_RESET_URL"`
+}
+
+type OIDC struct {
+	Issuer       string `yaml:"issuer" toml:"issuer" env:"OIDC_ISSUER"`
+	ClientID     string `yaml:"client_id" toml:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+	RedirectURL  string `yaml:"redirect_url" toml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+	Scopes       string `yaml:"scopes" toml:"scopes" env:"OIDC_SCOPES"`
+	GroupsClaim  string `yaml:"groups_claim" toml:"groups_claim" env:"OIDC_GROUPS_CLAIM" default:"groups"`
+	RoleMap      string `yaml:"role_map" toml:"role_map" env:"OIDC_ROLE_MAP"`
+	DefaultRole  string `yaml:"default_role" toml:"default_role" env:"OIDC_DEFAULT_ROLE" default:"viewer"`
+	Organization string `yaml:"organization" toml:"organization" env:"OIDC_ORGANIZATION"`
+	SuccessURL   string `yaml:"success_url" toml:"success_url" env:"OIDC_SUCCESS_URL"`
+
+	// Development only: a local provider logging everybody in as MockEmail
+	MockProvider string `yaml:"mock_provider" toml:"mock_provider" env:"OIDC_MOCK_PROVIDER"`
+	MockEmail    string `yaml:"mock_email" toml:"mock_email" env:"OIDC_MOCK_EMAIL" default:"user@example.com"`
+	MockGroups   string `yaml:"mock_groups" toml:"mock_groups" env:"OIDC_MOCK_GROUPS"`
+}
+
+type Ingest struct {
+	BulkBatchSize   int    `yaml:"bulk_batch_size" toml:"bulk_batch_size" env:"BULK_BATCH_SIZE" default:"500"`
+	ValidationRules string `yaml:"validation_rules" toml:"validation_rules" env:"VALIDATION_RULES"`
+	// A device sending no reading for this long is reported offline on /stream
+	OfflineAfter time.Duration `yaml:"offline_after" toml:"offline_after" env:"DEVICE_OFFLINE_AFTER" default:"2m"`
+}
+
+type Modbus struct {
+	Config    string `yaml:"config" toml:"config" env:"MODBUS_CONFIG"`
+	Simulator string `yaml:"simulator" toml:"simulator" env:"MODBUS_SIMULATOR"`
+}
+
+type Log struct {
+	// debug, info, warn or error
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL" default:"info"`
+	// text or json
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT" default:"text"`
+}
+
+// Load reads the configuration: defaults, then the CONFIG_FILE (.yaml, .yml or .toml), then the
+// .env file of GO_ENV if there is one, then the environment. The result is validated.
+func Load() (Config, error) {
+	var config Config
+	if err := walk(&config, func(field reflect.Value, tag reflect.StructTag) error {
+		if value, ok := tag.Lookup("default"); ok {
+			return set(field, value)
+		}
+		return nil
+	}); err != nil {
+		return Config{}, err
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadFile(path, &config); err != nil {
+			return Config{}, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	if err := env.LoadEnv(); err != nil {
+		return Config{}, err
+	}
+
+	if err := walk(&config, func(field reflect.Value, tag reflect.StructTag) error {
+		if value, ok := os.LookupEnv(tag.Get("env")); ok && tag.Get("env") != "" {
+			if err := set(field, value); err != nil {
+				return fmt.Errorf("%s: %w", tag.Get("env"), err)
+			}
+		}
+		return nil
+	}); err != nil {
+		return Config{}, err
+	}
+
+	return config, config.Validate()
+}
+
+// Validate reports every missing or invalid setting at once
+func (c Config) Validate() error {
+	var problems []string
+	require := func(value string, key string) {
+		if value == "" {
+			problems = append(problems, key+" is required")
+		}
+	}
+
+	if c.Port <= 0 || c.Port > 65535 {
+		problems = append(problems, "PORT must be between 1 and 65535")
+	}
+	require(c.Mongo.URI, "MONGO_URI")
+	require(c.Mongo.Database, "MONGO_DB")
+	require(c.Mongo.Collection, "MONGO_COLLECTION")
+	require(c.Mongo.UserCollection, "MONGO_USERCOLLECTION")
+	require(c.Mongo.DeviceCollection, "MONGO_DEVICECOLLECTION")
+	require(c.MQTT.Broker, "MQTT_BROKER")
+	if c.Auth.SessionTTL <= 0 {
+		problems = append(problems, "SESSION_TTL must be positive")
+	}
+	if c.MetricsPort != 0 && c.MetricsPort == c.Port {
+		problems = append(problems, "METRICS_PORT must differ from PORT, the metrics are not for the public")
+	}
+	if c.Ingest.BulkBatchSize <= 0 {
+		problems = append(problems, "BULK_BATCH_SIZE must be positive")
+	}
+	if c.Ingest.OfflineAfter <= 0 {
+		problems = append(problems, "DEVICE_OFFLINE_AFTER must be positive")
+	}
+	if c.SMTP.Username != "" {
+		require(c.SMTP.Password, "SMTP_PASSWORD")
+	}
+	if c.OIDC.Issuer != "" {
+		require(c.OIDC.ClientID, "OIDC_CLIENT_ID")
+		require(c.OIDC.RedirectURL, "OIDC_REDIRECT_URL")
+		if _, err := url.ParseRequestURI(c.OIDC.Issuer); err != nil {
+			problems = append(problems, "OIDC_ISSUER must be a URL")
+		}
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		problems = append(problems, "LOG_LEVEL must be debug, info, warn or error")
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		problems = append(problems, "LOG_FORMAT must be text or json")
+	}
+	if !c.Auth.PasswordLogin && c.OIDC.Issuer == "" {
+		problems = append(problems, "PASSWORD_LOGIN=false needs single sign-on (OIDC_ISSUER), nobody could log in")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Redacted returns a copy with every secret that is set replaced by "********", safe to print
+func (c Config) Redacted() Config {
+	walk(&c, func(field reflect.Value, tag reflect.StructTag) error {
+		if tag.Get("secret") == "true" && !field.IsZero() {
+			field.SetString("********")
+		}
+		return nil
+	})
+	return c
+}
+
+// Print writes the redacted configuration as YAML, for `config print`
+func (c Config) Print() error {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+func loadFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+		decoder.KnownFields(true)
+		return decoder.Decode(config)
+	case ".toml":
+		metadata, err := toml.Decode(string(data), config)
+		if err != nil {
+			return err
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("unknown setting %s", undecoded[0])
+		}
+		return nil
+	default:
+		return errors.New("use a .yaml, .yml or .toml file")
+	}
+}
+
+// walk calls fn for every setting, descending into the sections
+func walk(config *Config, fn func(field reflect.Value, tag reflect.StructTag) error) error {
+	var visit func(value reflect.Value) error
+	visit = func(value reflect.Value) error {
+		for i := 0; i < value.NumField(); i++ {
+			field, structField := value.Field(i), value.Type().Field(i)
+			if field.Kind() == reflect.Struct && field.Type() != reflect.TypeOf(time.Duration(0)) {
+				if err := visit(field); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := fn(field, structField.Tag); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return visit(reflect.ValueOf(config).Elem())
+}
+
+// set parses a text value into a setting
+func set(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(number))
+	case field.Kind() == reflect.Bool:
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(flag)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
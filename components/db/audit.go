@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditFilter selects audit entries; empty fields match everything. From and To are Unix milliseconds.
+type AuditFilter struct {
+	Actor   string
+	Action  string
+	Target  string
+	Outcome string
+	From    int64
+	To      int64
+	Limit   int64
+}
+
+// auditLog returns the append-only collection of audit entries. The server only ever inserts
+// into it; there are deliberately no functions to update or delete entries.
+func auditLog() *mongo.Collection {
+	return collectionNamed(settings.AuditCollection)
+}
+
+// ensureAuditIndexes speeds up the newest-first queries of an organization's audit log
+func ensureAuditIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := auditLog().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "organization", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "timestamp", Value: -1}}},
+	})
+	return err
+}
+
+// StoreAudit appends an entry to the audit log
+func StoreAudit(entry schema.AuditEntry) (bool, error) {
+	defer metrics.Query("StoreAudit")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := stampAudit(&entry); err != nil {
+		return false, err
+	}
+	if _, err := auditLog().InsertOne(ctx, entry); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetAudit returns the newest audit entries of an organization matching the filter.
+// The default organization also sees entries not tied to any organization, e.g. failed logins of unknown emails.
+func GetAudit(organization string, auditFilter AuditFilter) ([]schema.AuditEntry, error) {
+	defer metrics.Query("GetAudit")()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := organizationScope(organization)
+	for field, value := range map[string]string{
+		"actor":   auditFilter.Actor,
+		"action":  auditFilter.Action,
+		"target":  auditFilter.Target,
+		"outcome": auditFilter.Outcome,
+	} {
+		if value != "" {
+			filter[field] = value
+		}
+	}
+	if auditFilter.From > 0 || auditFilter.To > 0 {
+		timestamp := bson.M{}
+		if auditFilter.From > 0 {
+			timestamp["$gte"] = auditFilter.From
+		}
+		if auditFilter.To > 0 {
+			timestamp["$lte"] = auditFilter.To
+		}
+		filter["timestamp"] = timestamp
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	if auditFilter.Limit > 0 {
+		findOptions.SetLimit(auditFilter.Limit)
+	}
+	cursor, err := auditLog().Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []schema.AuditEntry{}
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func stampAudit(entry *schema.AuditEntry) error {
+	loc, err := time.LoadLocation("Asia/Bangkok")
+	if err != nil {
+		return err
+	}
+	currentTime := time.Now().In(loc)
+	entry.DateTime = currentTime.Format(time.RFC3339)
+	entry.TimeStamp = currentTime.UnixMilli()
+	return nil
+}
@@ -4,30 +4,36 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
-	env "GOLANG_SERVER/components/env"
+	"GOLANG_SERVER/components/config"
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/metrics"
 	schema "GOLANG_SERVER/components/schema"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var logger = logging.For("db")
+
 // * mongo db connection
 var client *mongo.Client
 var collection *mongo.Collection
+var settings config.Mongo
 
 // * Connect to mongo db
-func Connect() (bool, error) {
-	clientOptions := options.Client().ApplyURI(env.GetEnv("MONGO_URI"))
+func Connect(mongoConfig config.Mongo) (bool, error) {
+	settings = mongoConfig
+	clientOptions := options.Client().ApplyURI(settings.URI)
 	var err error
 	client, err = mongo.Connect(context.Background(), clientOptions)
 	if err != nil {
-		fmt.Println("Can't connect to mongo db:", err)
+		logger.Error("Can't connect to mongo db", "error", err)
 		return false, err
 	}
 
@@ -36,40 +42,161 @@ func Connect() (bool, error) {
 	defer cancel()
 	err = client.Ping(ctx, nil)
 	if err != nil {
-		fmt.Println("Can't ping mongo db:", err)
+		logger.Error("Can't ping mongo db", "error", err)
 		return false, err
 	}
 
-	collection = client.Database(env.GetEnv("MONGO_DB")).Collection(env.GetEnv("MONGO_COLLECTION"))
+	collection = readings()
+
+	// Unique indexes used to suppress duplicate readings
+	if err := ensureDuplicateIndexes(); err != nil {
+		logger.Warn("Can't create duplicate detection indexes", "error", err)
+	}
+	if err := ensureSessionIndexes(); err != nil {
+		logger.Warn("Can't create session indexes", "error", err)
+	}
+	if err := ensurePasswordResetIndexes(); err != nil {
+		logger.Warn("Can't create password reset indexes", "error", err)
+	}
+	if err := ensureAuditIndexes(); err != nil {
+		logger.Warn("Can't create audit log indexes", "error", err)
+	}
+	if err := ensureReadingIndexes(); err != nil {
+		logger.Warn("Can't create reading indexes", "error", err)
+	}
+	if err := migrateDefaultOrganization(); err != nil {
+		logger.Error("Can't move existing data into the default organization", "error", err)
+		return false, err
+	}
 	return true, nil
 }
 
+// HealthCheck pings mongo db for /readyz
+func HealthCheck(ctx context.Context) (any, error) {
+	if client == nil {
+		return nil, errors.New("not connected")
+	}
+	return nil, client.Ping(ctx, nil)
+}
+
 // * store data to mongo db and use upper camel case for function name
-func StoreGyroData(data schema.GyroData) (bool, error) {
+// The reading is stamped with the current time. A reading that was already stored (same device and message id,
+// sequence or device timestamp) is not stored again: it returns false with a nil error and is counted as a duplicate.
+func StoreGyroData(data *schema.GyroData) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) // Create a context with timeout
 	defer cancel()                                                           // Defer cancel the context
 
-	// load Bangkok timezone
-	loc, err := time.LoadLocation("Asia/Bangkok")
+	if err := stampNow(data); err != nil {
+		return false, err
+	}
+	// * the ID is set here instead of by the driver, the reading's event carries it
+	data.ID = primitive.NewObjectID()
+	done := metrics.Insert("one")
+	_, err := readings().InsertOne(ctx, *data)
+	done()
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			countDuplicate(data.DeviceAddress)
+			return false, nil
+		}
 		return false, err
 	}
+	return true, nil
+}
 
-	currentTime := time.Now().In(loc) // Get current time
+// StoreGyroDataBatch stores many readings with a single insert. The returned slices are indexed like
+// the batch: stored is false for readings skipped as duplicates, errs holds the error of readings that failed.
+func StoreGyroDataBatch(batch []schema.GyroData) ([]bool, []error) {
+	for i := range batch {
+		if err := stampNow(&batch[i]); err != nil {
+			errs := make([]error, len(batch))
+			for j := range errs {
+				errs[j] = err
+			}
+			return make([]bool, len(batch)), errs
+		}
+	}
+	return insertBatch(batch, "batch")
+}
+
+// ImportGyroDataBatch stores historical readings like StoreGyroDataBatch, keeping their own DateTime and TimeStamp
+func ImportGyroDataBatch(batch []schema.GyroData) ([]bool, []error) {
+	return insertBatch(batch, "import")
+}
 
-	data.DateTime = currentTime.Format(time.RFC3339) // Set timestamp to current time
-	data.TimeStamp = currentTime.UnixMilli()         // Set timestamp to current time
-	_, err = collection.InsertOne(ctx, data)
+func insertBatch(batch []schema.GyroData, operation string) ([]bool, []error) {
+	stored := make([]bool, len(batch))
+	errs := make([]error, len(batch))
+	if len(batch) == 0 {
+		return stored, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	documents := make([]interface{}, len(batch))
+	for i := range batch {
+		batch[i].ID = primitive.NewObjectID()
+		documents[i] = batch[i]
+		stored[i] = true
+	}
+
+	// Unordered so one bad reading does not stop the rest of the batch
+	done := metrics.Insert(operation)
+	_, err := readings().InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	done()
+	if err == nil {
+		return stored, errs
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		for i := range batch {
+			stored[i] = false
+			errs[i] = err
+		}
+		return stored, errs
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		stored[writeErr.Index] = false
+		if mongo.IsDuplicateKeyError(writeErr) {
+			countDuplicate(batch[writeErr.Index].DeviceAddress)
+			continue
+		}
+		errs[writeErr.Index] = writeErr
+	}
+	return stored, errs
+}
+
+// stampNow sets the reading's time to the current server time
+func stampNow(data *schema.GyroData) error {
+	currentTime := time.Now() // Get current time
+
+	dateTime, err := DateTimeOf(currentTime)
 	if err != nil {
-		return false, err
+		return err
 	}
-	return true, nil
+	data.DateTime = dateTime                 // Set timestamp to current time
+	data.TimeStamp = currentTime.UnixMilli() // Set timestamp to current time
+	return nil
 }
 
-func GetGyroData() ([]schema.GyroData, error) {
+// DateTimeOf formats a time the way DateTime is stored, RFC 3339 in Bangkok time
+func DateTimeOf(at time.Time) (string, error) {
+	// load Bangkok timezone
+	loc, err := time.LoadLocation("Asia/Bangkok")
+	if err != nil {
+		return "", err
+	}
+	return at.In(loc).Format(time.RFC3339), nil
+}
+
+// * every query on readings and devices is scoped to one organization
+func GetGyroData(organization string) ([]schema.GyroData, error) {
+	defer metrics.Query("GetGyroData")()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	cursor, err := collection.Find(ctx, bson.M{})
+	cursor, err := readings().Find(ctx, bson.M{"organization": organization})
 	if err != nil {
 		return nil, err
 	}
@@ -82,14 +209,15 @@ func GetGyroData() ([]schema.GyroData, error) {
 	return gyroData, nil
 }
 
-func GetGyroDataByDeviceAddress(DeviceAddress string) ([]schema.GyroData, error) {
+func GetGyroDataByDeviceAddress(organization string, DeviceAddress string) ([]schema.GyroData, error) {
+	defer metrics.Query("GetGyroDataByDeviceAddress")()
 	if len(DeviceAddress) == 0 {
 		return []schema.GyroData{}, errors.New("device address is empty")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	cursor, err := collection.Find(ctx, bson.M{strings.ToLower("DeviceAddress"): DeviceAddress})
+	cursor, err := readings().Find(ctx, bson.M{strings.ToLower("DeviceAddress"): DeviceAddress, "organization": organization})
 	if err != nil {
 		return nil, err
 	}
@@ -102,16 +230,16 @@ func GetGyroDataByDeviceAddress(DeviceAddress string) ([]schema.GyroData, error)
 	return gyroData, nil
 }
 
-func GetGyroDataByDeviceAddressLatest(DeviceAddress string) ([]schema.GyroData, error) {
+func GetGyroDataByDeviceAddressLatest(organization string, DeviceAddress string) ([]schema.GyroData, error) {
+	defer metrics.Query("GetGyroDataByDeviceAddressLatest")()
 	if len(DeviceAddress) == 0 {
 		return nil, errors.New("device address is empty")
 	}
-	collection = client.Database(env.GetEnv("MONGO_DB")).Collection(env.GetEnv("MONGO_COLLECTION"))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	var gyroData []schema.GyroData
-	cursor, err := collection.Find(ctx, bson.M{strings.ToLower("deviceaddress"): DeviceAddress}, options.Find().SetSort(bson.D{{Key: strings.ToLower("timestamp"), Value: -1}}).SetLimit(50))
+	cursor, err := readings().Find(ctx, bson.M{strings.ToLower("deviceaddress"): DeviceAddress, "organization": organization}, options.Find().SetSort(bson.D{{Key: strings.ToLower("timestamp"), Value: -1}}).SetLimit(50))
 	if err != nil {
 		return nil, err
 	}
@@ -122,33 +250,36 @@ func GetGyroDataByDeviceAddressLatest(DeviceAddress string) ([]schema.GyroData,
 	}
 
 	if len(gyroData) == 0 {
-		return nil, errors.New("no data found")
+		return nil, fmt.Errorf("readings %w", ErrNotFound)
 	}
 	return gyroData, nil
 }
 
-func CleanData() (bool, error) {
+func CleanData(organization string) (bool, error) {
+	defer metrics.Query("CleanData")()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	_, err := collection.DeleteMany(ctx, bson.M{})
+	_, err := readings().DeleteMany(ctx, bson.M{"organization": organization})
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func RegisterDevice(DeviceAddress string) (bool, error) {
+// RegisterDevice registers a device for an organization; a device address can only belong to one organization
+func RegisterDevice(organization string, DeviceAddress string) (bool, error) {
+	defer metrics.Query("RegisterDevice")()
 	if len(DeviceAddress) == 0 {
 		return false, errors.New("device address is empty")
 	}
-	collection = client.Database(env.GetEnv("MONGO_DB")).Collection(env.GetEnv("MONGO_DEVICECOLLECTION"))
+	collection := devices()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Check if device already exists
+	// Check if device already exists, in any organization
 	filter := bson.M{"deviceaddress": DeviceAddress}
 
-	log.Println("Querying database with filter:", filter)
+	logger.Debug("Querying database", "filter", filter)
 
 	// Check if device already exists
 	var result struct {
@@ -156,26 +287,26 @@ func RegisterDevice(DeviceAddress string) (bool, error) {
 	}
 	err := collection.FindOne(ctx, filter).Decode(&result)
 	if err == nil { // Device already exists
-		return false, errors.New("device already exists")
+		return false, fmt.Errorf("device %w", ErrExists)
 	} else if err != mongo.ErrNoDocuments {
 		return false, err
 	} else {
-		_, err := collection.InsertOne(ctx, bson.M{"deviceaddress": DeviceAddress})
+		_, err := collection.InsertOne(ctx, bson.M{"deviceaddress": DeviceAddress, "organization": organization})
 		if err != nil {
 			return false, err
 		}
 
-		log.Println("Device registered successfully.")
+		logger.Info("Device registered", "device", DeviceAddress, "organization", organization)
 	}
 
 	return true, nil
 }
 
-func GetDeviceAddress() ([]string, error) {
-	collection = client.Database(env.GetEnv("MONGO_DB")).Collection(env.GetEnv("MONGO_DEVICECOLLECTION"))
+func GetDeviceAddress(organization string) ([]string, error) {
+	defer metrics.Query("GetDeviceAddress")()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) // Create a context with timeout
 	defer cancel()
-	cursor, err := collection.Find(ctx, bson.M{})
+	cursor, err := devices().Find(ctx, bson.M{"organization": organization})
 	if err != nil {
 		return nil, err
 	}
@@ -197,14 +328,14 @@ func GetDeviceAddress() ([]string, error) {
 	return deviceAddresses, nil
 }
 
-func GetDeviceAddressByDeviceAddress(deviceAddress string) ([]string, error) {
-	collection = client.Database(env.GetEnv("MONGO_DB")).Collection(env.GetEnv("MONGO_DEVICECOLLECTION"))
+func GetDeviceAddressByDeviceAddress(organization string, deviceAddress string) ([]string, error) {
+	defer metrics.Query("GetDeviceAddressByDeviceAddress")()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) // Create a context with timeout
 	defer cancel()
 
-	filter := bson.M{"deviceaddress": deviceAddress}
-	log.Println("Querying database with filter:", filter)
-	cursor, err := collection.Find(ctx, filter)
+	filter := bson.M{"deviceaddress": deviceAddress, "organization": organization}
+	logger.Debug("Querying database", "filter", filter)
+	cursor, err := devices().Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -223,16 +354,16 @@ func GetDeviceAddressByDeviceAddress(deviceAddress string) ([]string, error) {
 	if err := cursor.Err(); err != nil {
 		return nil, err
 	}
-	log.Println("Found device addresses:", deviceAddresses)
+	logger.Debug("Found device addresses", "devices", deviceAddresses)
 	return deviceAddresses, nil
 }
 
 // get data from collection data in mongoDB by device address
-func GetDataByDeviceAddress(deviceAddress string) ([]schema.GyroData, error) {
-	collection = client.Database(env.GetEnv("MONGO_DB")).Collection(env.GetEnv("MONGO_COLLECTION")) // Get collection data
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)                        // Create a context with timeout
-	defer cancel()                                                                                  // Defer cancel the context
-	cursor, err := collection.Find(ctx, bson.M{"deviceaddress": deviceAddress})                     // Find data by device address
+func GetDataByDeviceAddress(organization string, deviceAddress string) ([]schema.GyroData, error) {
+	defer metrics.Query("GetDataByDeviceAddress")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)                                  // Create a context with timeout
+	defer cancel()                                                                                            // Defer cancel the context
+	cursor, err := readings().Find(ctx, bson.M{"deviceaddress": deviceAddress, "organization": organization}) // Find data by device address
 	if err != nil {
 		return nil, err
 	}
@@ -247,16 +378,17 @@ func GetDataByDeviceAddress(deviceAddress string) ([]schema.GyroData, error) {
 
 // Store Email and Password to mongoDB collection user
 func StoreUser(user schema.User) (bool, error) {
-	collection = client.Database(env.GetEnv("MONGO_DB")).Collection(env.GetEnv("MONGO_USERCOLLECTION")) // Get collection user
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)                            // Create a context with timeout
-	defer cancel()                                                                                      // Defer cancel the context
+	defer metrics.Query("StoreUser")()
+	collection := users()                                                    // Get collection user
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) // Create a context with timeout
+	defer cancel()                                                           // Defer cancel the context
 
 	// Check if user already exists
 	filter := bson.M{"email": user.Email}
 	var result schema.User
 	err := collection.FindOne(ctx, filter).Decode(&result)
 	if err == nil {
-		return false, errors.New("user already exists")
+		return false, fmt.Errorf("user %w", ErrExists)
 	} else if err != mongo.ErrNoDocuments {
 		return false, err
 	} else {
@@ -271,9 +403,10 @@ func StoreUser(user schema.User) (bool, error) {
 
 // Login checks if the user exists and returns the user object and an error
 func Login(email string, password string) (schema.User, error) {
-	collection = client.Database(env.GetEnv("MONGO_DB")).Collection(env.GetEnv("MONGO_USERCOLLECTION")) // Get collection user
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)                            // Create a context with timeout
-	defer cancel()                                                                                      // Defer cancel the context
+	defer metrics.Query("Login")()
+	collection := users()                                                    // Get collection user
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) // Create a context with timeout
+	defer cancel()                                                           // Defer cancel the context
 
 	// Check if user exists
 	filter := bson.M{"email": email}
@@ -281,12 +414,12 @@ func Login(email string, password string) (schema.User, error) {
 	err := collection.FindOne(ctx, filter).Decode(&result)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return schema.User{}, errors.New("user not found")
+			return schema.User{}, fmt.Errorf("user %w", ErrNotFound)
 		}
 		return schema.User{}, err
 	}
 
-	log.Println("User found:", email)
+	logger.Debug("User found", "email", email)
 
 	// In Database Password is hashed
 	// Compare the stored password with the input password
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// * duplicate readings suppressed per device since the server started
+var duplicates = make(map[string]int64)
+var duplicatesMutex sync.Mutex
+
+// readings returns the collection the gyro data is stored in
+func readings() *mongo.Collection {
+	return collectionNamed(settings.Collection)
+}
+
+// ensureDuplicateIndexes creates the unique indexes a retried reading collides with.
+// Each index only covers readings carrying its identifiers, so readings without any are never rejected.
+// A sequence is only unique within a boot of the gateway, its index also covers the boot id.
+func ensureDuplicateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var indexes []mongo.IndexModel
+	for _, fields := range [][]string{{"messageid"}, {"bootid", "sequence"}, {"devicetimestamp"}, {"importhash"}} {
+		keys := bson.D{{Key: "deviceaddress", Value: 1}}
+		filter := bson.M{}
+		for _, field := range fields {
+			keys = append(keys, bson.E{Key: field, Value: 1})
+			filter[field] = bson.M{"$exists": true}
+		}
+		indexes = append(indexes, mongo.IndexModel{
+			Keys: keys,
+			Options: options.Index().
+				SetName("unique_deviceaddress_" + strings.Join(fields, "_")).
+				SetUnique(true).
+				SetPartialFilterExpression(filter),
+		})
+	}
+
+	if _, err := readings().Indexes().CreateMany(ctx, indexes); err != nil {
+		return err
+	}
+	return nil
+}
+
+func countDuplicate(deviceAddress string) {
+	duplicatesMutex.Lock()
+	defer duplicatesMutex.Unlock()
+	duplicates[deviceAddress]++
+	logger.Debug("Duplicate reading suppressed", "device", deviceAddress)
+}
+
+// GetDuplicateCounts returns how many duplicate readings were suppressed per device
+func GetDuplicateCounts() map[string]int64 {
+	duplicatesMutex.Lock()
+	defer duplicatesMutex.Unlock()
+
+	counts := make(map[string]int64, len(duplicates))
+	for deviceAddress, count := range duplicates {
+		counts[deviceAddress] = count
+	}
+	return counts
+}
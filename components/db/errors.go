@@ -0,0 +1,9 @@
+package db
+
+import "errors"
+
+// * wrapped by the errors of the db functions ("user not found"), test them with errors.Is
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+)
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReadingFilter selects readings; empty fields match everything. From and To are Unix milliseconds.
+// ByDevice sorts by device before time, to walk through one device after the other.
+type ReadingFilter struct {
+	DeviceAddress string
+	// Devices selects the readings of any of several devices
+	Devices  []string
+	From     int64
+	To       int64
+	ByDevice bool
+	// Limit is the most readings returned, all if 0
+	Limit int64
+}
+
+// ensureReadingIndexes speeds up the time range queries of a device, e.g. exports
+func ensureReadingIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := readings().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "organization", Value: 1}, {Key: "deviceaddress", Value: 1}, {Key: "timestamp", Value: 1}},
+	})
+	return err
+}
+
+// EachGyroData calls fn for every reading of an organization matching the filter, oldest first; readings
+// of the same time always come in the same order. The readings are read from a cursor one batch at a time,
+// so any number of them can be walked through without holding them in memory. It stops at the first error,
+// also the one of fn.
+func EachGyroData(ctx context.Context, organization string, readingFilter ReadingFilter, fn func(schema.GyroData) error) error {
+	defer metrics.Query("EachGyroData")()
+
+	sort := bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}
+	if readingFilter.ByDevice {
+		sort = append(bson.D{{Key: "deviceaddress", Value: 1}}, sort...)
+	}
+	findOptions := options.Find().SetSort(sort).SetBatchSize(1000)
+	if readingFilter.Limit > 0 {
+		findOptions.SetLimit(readingFilter.Limit)
+	}
+	cursor, err := readings().Find(ctx, readingFilter.query(organization), findOptions)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var data schema.GyroData
+		if err := cursor.Decode(&data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Readings deleted by one DeleteGyroData query, the IDs of a query have to fit into a 16 MB document
+const deleteChunk = 10000
+
+// DeleteGyroData deletes readings of an organization by ID, e.g. the ones written to an archive
+func DeleteGyroData(organization string, ids []primitive.ObjectID) (int64, error) {
+	defer metrics.Query("DeleteGyroData")()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var deleted int64
+	for start := 0; start < len(ids); start += deleteChunk {
+		chunk := ids[start:min(start+deleteChunk, len(ids))]
+		result, err := readings().DeleteMany(ctx, bson.M{"organization": organization, "_id": bson.M{"$in": chunk}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.DeletedCount
+	}
+	return deleted, nil
+}
+
+func (readingFilter ReadingFilter) query(organization string) bson.M {
+	filter := bson.M{"organization": organization}
+	if readingFilter.DeviceAddress != "" {
+		filter["deviceaddress"] = readingFilter.DeviceAddress
+	} else if len(readingFilter.Devices) > 0 {
+		filter["deviceaddress"] = bson.M{"$in": readingFilter.Devices}
+	}
+	if readingFilter.From > 0 || readingFilter.To > 0 {
+		timestamp := bson.M{}
+		if readingFilter.From > 0 {
+			timestamp["$gte"] = readingFilter.From
+		}
+		if readingFilter.To > 0 {
+			timestamp["$lte"] = readingFilter.To
+		}
+		filter["timestamp"] = timestamp
+	}
+	return filter
+}
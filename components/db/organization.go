@@ -0,0 +1,198 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// devices returns the collection of registered devices
+func devices() *mongo.Collection {
+	return collectionNamed(settings.DeviceCollection)
+}
+
+// organizations returns the collection of tenants
+func organizations() *mongo.Collection {
+	return collectionNamed(settings.OrganizationCollection)
+}
+
+// migrateDefaultOrganization moves users, devices and readings stored before organizations existed
+// into the default organization, so they stay visible to the people who could see them before
+func migrateDefaultOrganization() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	_, err := organizations().UpdateOne(ctx,
+		bson.M{"slug": schema.DefaultOrganization},
+		bson.M{"$setOnInsert": schema.Organization{Slug: schema.DefaultOrganization, Name: "Default", MQTTPrefix: schema.DefaultOrganization}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+
+	unassigned := bson.M{"organization": bson.M{"$exists": false}}
+	assign := bson.M{"$set": bson.M{"organization": schema.DefaultOrganization}}
+	for _, collection := range []*mongo.Collection{users(), devices(), readings()} {
+		if _, err := collection.UpdateMany(ctx, unassigned, assign); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// organizationScope filters the records an organization may see, e.g. quarantined payloads or audit entries.
+// Records not tied to an organization, such as payloads from open channels, can only be seen by the default one.
+func organizationScope(organization string) bson.M {
+	if organization == schema.DefaultOrganization {
+		return bson.M{"organization": bson.M{"$in": bson.A{organization, nil}}}
+	}
+	return bson.M{"organization": organization}
+}
+
+// StoreOrganization creates a tenant, the slug and MQTT prefix must be unique
+func StoreOrganization(organization schema.Organization) (bool, error) {
+	defer metrics.Query("StoreOrganization")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{bson.M{"slug": organization.Slug}, bson.M{"mqttprefix": organization.MQTTPrefix}}}
+	err := organizations().FindOne(ctx, filter).Err()
+	if err == nil {
+		return false, fmt.Errorf("organization or MQTT prefix %w", ErrExists)
+	} else if err != mongo.ErrNoDocuments {
+		return false, err
+	}
+
+	if _, err := organizations().InsertOne(ctx, organization); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetOrganizations returns every tenant
+func GetOrganizations() ([]schema.Organization, error) {
+	defer metrics.Query("GetOrganizations")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := organizations().Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := []schema.Organization{}
+	if err = cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetOrganizationByPrefix returns the tenant publishing on an MQTT topic prefix
+func GetOrganizationByPrefix(prefix string) (schema.Organization, error) {
+	defer metrics.Query("GetOrganizationByPrefix")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var organization schema.Organization
+	if err := organizations().FindOne(ctx, bson.M{"mqttprefix": prefix}).Decode(&organization); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return schema.Organization{}, fmt.Errorf("organization %w", ErrNotFound)
+		}
+		return schema.Organization{}, err
+	}
+	return organization, nil
+}
+
+// GetOrganization returns a tenant by slug
+func GetOrganization(slug string) (schema.Organization, error) {
+	defer metrics.Query("GetOrganization")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var organization schema.Organization
+	if err := organizations().FindOne(ctx, bson.M{"slug": slug}).Decode(&organization); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return schema.Organization{}, fmt.Errorf("organization %w", ErrNotFound)
+		}
+		return schema.Organization{}, err
+	}
+	return organization, nil
+}
+
+// SetTwoFactorRoles sets which roles of an organization must use two-factor authentication
+func SetTwoFactorRoles(slug string, roles []string) (bool, error) {
+	defer metrics.Query("SetTwoFactorRoles")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := organizations().UpdateOne(ctx, bson.M{"slug": slug}, bson.M{"$set": bson.M{"twofactorroles": roles}})
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, fmt.Errorf("organization %w", ErrNotFound)
+	}
+	return true, nil
+}
+
+// GetDeviceOrganization returns the organization a device is registered to, or "" if it is not registered
+func GetDeviceOrganization(deviceAddress string) (string, error) {
+	defer metrics.Query("GetDeviceOrganization")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var result struct {
+		Organization string `bson:"organization"`
+	}
+	err := devices().FindOne(ctx, bson.M{"deviceaddress": deviceAddress}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return result.Organization, nil
+}
+
+// DeviceVisible reports whether an organization may see a device. Unregistered devices
+// belong to the default organization, like their readings do.
+func DeviceVisible(organization string, deviceAddress string) (bool, error) {
+	deviceOrganization, err := GetDeviceOrganization(deviceAddress)
+	if err != nil {
+		return false, err
+	}
+	if deviceOrganization == "" {
+		return organization == schema.DefaultOrganization, nil
+	}
+	return deviceOrganization == organization, nil
+}
+
+// SetUserOrganization moves a user into an organization
+func SetUserOrganization(email string, organization string) (bool, error) {
+	defer metrics.Query("SetUserOrganization")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := organizations().FindOne(ctx, bson.M{"slug": organization}).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, fmt.Errorf("organization %w", ErrNotFound)
+		}
+		return false, err
+	}
+
+	result, err := users().UpdateOne(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"organization": organization}})
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, fmt.Errorf("user %w", ErrNotFound)
+	}
+	return true, nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// passwordResets returns the collection of pending password reset tokens
+func passwordResets() *mongo.Collection {
+	return collectionNamed(settings.PasswordResetCollection)
+}
+
+// ensurePasswordResetIndexes lets MongoDB remove expired reset tokens on its own
+func ensurePasswordResetIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := passwordResets().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenhash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresat", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// CreatePasswordReset returns a single-use reset token for the user, replacing any earlier one
+func CreatePasswordReset(email string, ttl time.Duration) (string, error) {
+	defer metrics.Query("CreatePasswordReset")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+
+	if _, err := passwordResets().DeleteMany(ctx, bson.M{"email": email}); err != nil {
+		return "", err
+	}
+	reset := bson.M{"tokenhash": hashToken(token), "email": email, "expiresat": time.Now().Add(ttl)}
+	if _, err := passwordResets().InsertOne(ctx, reset); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ErrSingleSignOn refuses a password for an account logging in through the identity provider
+var ErrSingleSignOn = errors.New("accounts using single sign-on have no password, manage them at the identity provider")
+
+// deletePasswordResets drops the reset tokens of a user
+func deletePasswordResets(email string) error {
+	defer metrics.Query("DeletePasswordResets")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := passwordResets().DeleteMany(ctx, bson.M{"email": email})
+	return err
+}
+
+// UsePasswordReset consumes a reset token and returns the email it was issued for. Accounts that
+// switched to single sign-on since the token was issued are refused.
+func UsePasswordReset(token string) (string, error) {
+	defer metrics.Query("UsePasswordReset")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// * deleting while reading makes the token unusable a second time, even by concurrent requests
+	var result struct {
+		Email string `bson:"email"`
+	}
+	filter := bson.M{"tokenhash": hashToken(token), "expiresat": bson.M{"$gt": time.Now()}}
+	if err := passwordResets().FindOneAndDelete(ctx, filter).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", errors.New("invalid or expired reset token")
+		}
+		return "", err
+	}
+	sso, err := users().CountDocuments(ctx, bson.M{"email": result.Email, "provider": schema.ProviderOIDC})
+	if err != nil {
+		return "", err
+	}
+	if sso > 0 {
+		return "", ErrSingleSignOn
+	}
+	return result.Email, nil
+}
+
+// SetUserPassword replaces the (already hashed) password of a user; single sign-on accounts have none
+func SetUserPassword(email string, hashedPassword string) (bool, error) {
+	defer metrics.Query("SetUserPassword")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"email": email, "provider": bson.M{"$ne": schema.ProviderOIDC}}
+	result, err := users().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"password": hashedPassword}})
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, fmt.Errorf("user %w", ErrNotFound)
+	}
+	return true, nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionNamed returns a collection of the configured database
+func collectionNamed(name string) *mongo.Collection {
+	return client.Database(settings.Database).Collection(name)
+}
+
+// StoreQuarantine keeps a rejected payload together with the reason it was rejected
+func StoreQuarantine(record schema.QuarantineRecord) (bool, error) {
+	defer metrics.Query("StoreQuarantine")()
+	quarantine := collectionNamed(settings.QuarantineCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	loc, err := time.LoadLocation("Asia/Bangkok")
+	if err != nil {
+		return false, err
+	}
+	currentTime := time.Now().In(loc)
+	record.DateTime = currentTime.Format(time.RFC3339)
+	record.TimeStamp = currentTime.UnixMilli()
+
+	if _, err := quarantine.InsertOne(ctx, record); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetQuarantine returns the newest quarantined payloads, optionally only those from one source
+func GetQuarantine(organization string, source string, limit int64) ([]schema.QuarantineRecord, error) {
+	defer metrics.Query("GetQuarantine")()
+	quarantine := collectionNamed(settings.QuarantineCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := organizationScope(organization)
+	if source != "" {
+		filter["source"] = source
+	}
+	cursor, err := quarantine.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	records := []schema.QuarantineRecord{}
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// GetQuarantineByID returns one quarantined payload
+func GetQuarantineByID(organization string, id string) (schema.QuarantineRecord, error) {
+	defer metrics.Query("GetQuarantineByID")()
+	// * an ID that isn't one can't name a stored payload
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return schema.QuarantineRecord{}, fmt.Errorf("quarantined payload %w", ErrNotFound)
+	}
+
+	quarantine := collectionNamed(settings.QuarantineCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := organizationScope(organization)
+	filter["_id"] = objectID
+
+	var record schema.QuarantineRecord
+	if err := quarantine.FindOne(ctx, filter).Decode(&record); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return schema.QuarantineRecord{}, fmt.Errorf("quarantined payload %w", ErrNotFound)
+		}
+		return schema.QuarantineRecord{}, err
+	}
+	return record, nil
+}
+
+// DeleteQuarantine removes a quarantined payload, e.g. after it was replayed successfully
+func DeleteQuarantine(id primitive.ObjectID) (bool, error) {
+	defer metrics.Query("DeleteQuarantine")()
+	quarantine := collectionNamed(settings.QuarantineCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := quarantine.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sessions returns the collection login sessions are stored in
+func sessions() *mongo.Collection {
+	return collectionNamed(settings.SessionCollection)
+}
+
+// ensureSessionIndexes lets MongoDB remove expired sessions on its own
+func ensureSessionIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sessions().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenhash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresat", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// CreateSession starts a session for the user and returns its bearer token
+func CreateSession(email string, ttl time.Duration) (string, error) {
+	defer metrics.Query("CreateSession")()
+	return createSession(schema.Session{Email: email}, ttl)
+}
+
+// CreateChallenge returns a token that only proves the password was right; it is
+// exchanged for a session once the second factor is checked
+func CreateChallenge(email string, ttl time.Duration) (string, error) {
+	defer metrics.Query("CreateChallenge")()
+	return createSession(schema.Session{Email: email, Challenge: true}, ttl)
+}
+
+func createSession(session schema.Session, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+
+	session.TokenHash = hashToken(token)
+	session.ExpiresAt = time.Now().Add(ttl)
+	if _, err := sessions().InsertOne(ctx, session); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetSession returns the unexpired session of a bearer token
+func GetSession(token string) (schema.Session, error) {
+	defer metrics.Query("GetSession")()
+	return getSession(token, false)
+}
+
+// GetChallenge returns the unexpired login challenge of a token
+func GetChallenge(token string) (schema.Session, error) {
+	defer metrics.Query("GetChallenge")()
+	return getSession(token, true)
+}
+
+func getSession(token string, challenge bool) (schema.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var session schema.Session
+	filter := bson.M{"tokenhash": hashToken(token), "expiresat": bson.M{"$gt": time.Now()}}
+	if challenge {
+		filter["challenge"] = true
+	} else {
+		filter["challenge"] = bson.M{"$ne": true}
+	}
+	if err := sessions().FindOne(ctx, filter).Decode(&session); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return schema.Session{}, fmt.Errorf("session %w", ErrNotFound)
+		}
+		return schema.Session{}, err
+	}
+	return session, nil
+}
+
+// DeleteSession ends the session of a bearer token
+func DeleteSession(token string) (bool, error) {
+	defer metrics.Query("DeleteSession")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := sessions().DeleteOne(ctx, bson.M{"tokenhash": hashToken(token)}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// DeleteSessionsByEmail ends every session of a user, e.g. after a password reset
+func DeleteSessionsByEmail(email string) (bool, error) {
+	defer metrics.Query("DeleteSessionsByEmail")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := sessions().DeleteMany(ctx, bson.M{"email": email}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteOtherSessions ends every session of the user except the one of token, e.g. after a password change
+func DeleteOtherSessions(email string, token string) (bool, error) {
+	defer metrics.Query("DeleteOtherSessions")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := sessions().DeleteMany(ctx, bson.M{"email": email, "tokenhash": bson.M{"$ne": hashToken(token)}}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
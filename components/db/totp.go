@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"GOLANG_SERVER/components/metrics"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SetTOTPPendingSecret keeps a secret until the user proves their authenticator app has it
+func SetTOTPPendingSecret(email string, secret string) (bool, error) {
+	defer metrics.Query("SetTOTPPendingSecret")()
+	return updateUser(email, bson.M{"$set": bson.M{"totppendingsecret": secret}})
+}
+
+// EnableTOTP activates the pending secret and replaces the recovery codes
+func EnableTOTP(email string, secret string, recoveryCodes []string) (bool, error) {
+	defer metrics.Query("EnableTOTP")()
+	hashes := make([]string, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return updateUser(email, bson.M{
+		"$set":   bson.M{"totpenabled": true, "totpsecret": secret, "recoverycodes": hashes},
+		"$unset": bson.M{"totppendingsecret": "", "totplaststep": ""},
+	})
+}
+
+// DisableTOTP removes two-factor authentication from an account
+func DisableTOTP(email string) (bool, error) {
+	defer metrics.Query("DisableTOTP")()
+	return updateUser(email, bson.M{
+		"$set":   bson.M{"totpenabled": false},
+		"$unset": bson.M{"totpsecret": "", "totppendingsecret": "", "totplaststep": "", "recoverycodes": ""},
+	})
+}
+
+// UseTOTPStep records the period of an accepted code; it fails if that code (or a later one) was already used
+func UseTOTPStep(email string, step int64) (bool, error) {
+	defer metrics.Query("UseTOTPStep")()
+	filter := bson.M{"email": email, "$or": bson.A{
+		bson.M{"totplaststep": bson.M{"$exists": false}},
+		bson.M{"totplaststep": bson.M{"$lt": step}},
+	}}
+	return updateUserWhere(filter, bson.M{"$set": bson.M{"totplaststep": step}}, errors.New("code already used"))
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes
+func UseRecoveryCode(email string, code string) (bool, error) {
+	defer metrics.Query("UseRecoveryCode")()
+	hash := hashRecoveryCode(code)
+	filter := bson.M{"email": email, "recoverycodes": hash}
+	return updateUserWhere(filter, bson.M{"$pull": bson.M{"recoverycodes": hash}}, errors.New("invalid recovery code"))
+}
+
+func updateUser(email string, update bson.M) (bool, error) {
+	return updateUserWhere(bson.M{"email": email}, update, fmt.Errorf("user %w", ErrNotFound))
+}
+
+func updateUserWhere(filter bson.M, update bson.M, notMatched error) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := users().UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, notMatched
+	}
+	return true, nil
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
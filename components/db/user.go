@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// users returns the collection the user accounts are stored in
+func users() *mongo.Collection {
+	return collectionNamed(settings.UserCollection)
+}
+
+// GetUserByEmail returns the user with the given email
+func GetUserByEmail(email string) (schema.User, error) {
+	defer metrics.Query("GetUserByEmail")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var result schema.User
+	if err := users().FindOne(ctx, bson.M{"email": email}).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return schema.User{}, fmt.Errorf("user %w", ErrNotFound)
+		}
+		return schema.User{}, err
+	}
+	return result, nil
+}
+
+// SetUserRole changes the role of a user of the organization
+func SetUserRole(organization string, email string, role string) (bool, error) {
+	defer metrics.Query("SetUserRole")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"email": email, "organization": organization}
+	result, err := users().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, fmt.Errorf("user %w", ErrNotFound)
+	}
+	return true, nil
+}
+
+// userScope filters the users an organization's admins may manage. The default organization
+// also sees the users that don't belong to any organization yet, so the operator can assign them.
+func userScope(organization string) bson.M {
+	if organization == schema.DefaultOrganization {
+		return bson.M{"organization": bson.M{"$in": bson.A{organization, "", nil}}}
+	}
+	return bson.M{"organization": organization}
+}
+
+// GetUsers returns the users of the organization sorted by email
+func GetUsers(organization string) ([]schema.User, error) {
+	defer metrics.Query("GetUsers")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := users().Find(ctx, userScope(organization), options.Find().SetSort(bson.D{{Key: "email", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := []schema.User{}
+	if err = cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetUserInOrganization returns a user the organization's admins may manage
+func GetUserInOrganization(organization string, email string) (schema.User, error) {
+	defer metrics.Query("GetUserInOrganization")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := userScope(organization)
+	filter["email"] = email
+
+	var result schema.User
+	if err := users().FindOne(ctx, filter).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return schema.User{}, fmt.Errorf("user %w", ErrNotFound)
+		}
+		return schema.User{}, err
+	}
+	return result, nil
+}
+
+// CountAdmins returns the number of enabled admins of the organization
+func CountAdmins(organization string) (int64, error) {
+	defer metrics.Query("CountAdmins")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return users().CountDocuments(ctx, bson.M{"organization": organization, "role": schema.RoleAdmin, "disabled": bson.M{"$ne": true}})
+}
+
+// UpdateProfile replaces the profile settings of a user
+func UpdateProfile(email string, displayName string, timezone string, notifications schema.NotificationPreferences) (bool, error) {
+	defer metrics.Query("UpdateProfile")()
+	return updateUser(email, bson.M{"$set": bson.M{
+		"displayname":   displayName,
+		"timezone":      timezone,
+		"notifications": notifications,
+	}})
+}
+
+// ChangeUserEmail moves an account to a new email, its sessions follow so the user stays logged in
+func ChangeUserEmail(email string, newEmail string) (bool, error) {
+	defer metrics.Query("ChangeUserEmail")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if count, err := users().CountDocuments(ctx, bson.M{"email": newEmail}); err != nil {
+		return false, err
+	} else if count > 0 {
+		return false, fmt.Errorf("email %w", ErrExists)
+	}
+	if _, err := updateUser(email, bson.M{"$set": bson.M{"email": newEmail}}); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, fmt.Errorf("email %w", ErrExists)
+		}
+		return false, err
+	}
+	if _, err := sessions().UpdateMany(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"email": newEmail}}); err != nil {
+		return false, err
+	}
+	// * reset links were sent to the old address
+	if _, err := passwordResets().DeleteMany(ctx, bson.M{"email": email}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetUserDisabled disables or re-enables a user of the organization; disabling ends their sessions
+func SetUserDisabled(organization string, email string, disabled bool) (bool, error) {
+	defer metrics.Query("SetUserDisabled")()
+	filter := userScope(organization)
+	filter["email"] = email
+	if _, err := updateUserWhere(filter, bson.M{"$set": bson.M{"disabled": disabled}}, fmt.Errorf("user %w", ErrNotFound)); err != nil {
+		return false, err
+	}
+	if disabled {
+		return DeleteSessionsByEmail(email)
+	}
+	return true, nil
+}
+
+// DeleteUser removes a user of the organization together with their sessions and password reset links
+func DeleteUser(organization string, email string) (bool, error) {
+	defer metrics.Query("DeleteUser")()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := userScope(organization)
+	filter["email"] = email
+	result, err := users().DeleteOne(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	if result.DeletedCount == 0 {
+		return false, fmt.Errorf("user %w", ErrNotFound)
+	}
+	if _, err := passwordResets().DeleteMany(ctx, bson.M{"email": email}); err != nil {
+		return false, err
+	}
+	return DeleteSessionsByEmail(email)
+}
+
+// ProvisionUser stores the account of a user who logged in through single sign-on, as sso.Account made it:
+// a new account is created, an existing one gets its subject, display name and role. Only single sign-on
+// accounts are updated, a local account is never converted by a login; an admin does that with AllowSSO.
+func ProvisionUser(user schema.User, created bool) error {
+	defer metrics.Query("ProvisionUser")()
+	if created {
+		_, err := StoreUser(user)
+		return err
+	}
+
+	// * the subject is bound on the first login, another subject can't take the account over meanwhile
+	filter := bson.M{
+		"email":    user.Email,
+		"provider": schema.ProviderOIDC,
+		"$or":      bson.A{bson.M{"subject": user.Subject}, bson.M{"subject": bson.M{"$exists": false}}},
+	}
+	update := bson.M{"$set": bson.M{"subject": user.Subject, "displayname": user.DisplayName, "role": user.Role}}
+	_, err := updateUserWhere(filter, update, fmt.Errorf("single sign-on account %w", ErrNotFound))
+	return err
+}
+
+// AllowSSO converts a local account of the organization into a single sign-on account: its password is
+// removed and the next single sign-on login with its email takes it over
+func AllowSSO(organization string, email string) (bool, error) {
+	defer metrics.Query("AllowSSO")()
+	filter := userScope(organization)
+	filter["email"] = email
+	update := bson.M{"$set": bson.M{"provider": schema.ProviderOIDC, "password": ""}, "$unset": bson.M{"subject": ""}}
+	if _, err := updateUserWhere(filter, update, fmt.Errorf("user %w", ErrNotFound)); err != nil {
+		return false, err
+	}
+	// * reset links sent before can't give the account a password again
+	if err := deletePasswordResets(email); err != nil {
+		return false, err
+	}
+	// * sessions opened with the password end, the user logs in again through the identity provider
+	return DeleteSessionsByEmail(email)
+}
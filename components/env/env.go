@@ -1,13 +1,16 @@
 package env
 
 import (
-	"log"
+	"errors"
+	"io/fs"
+	"log/slog"
 	"os"
 
 	"github.com/joho/godotenv"
 )
 
-// LoadEnv loads the environment variables from the appropriate .env file
+// LoadEnv loads the environment variables from the appropriate .env file. The file is optional:
+// without it the real environment variables are used. Variables already set are never overridden.
 func LoadEnv() error {
 	env := os.Getenv("GO_ENV")
 	var envFile string
@@ -22,15 +25,13 @@ func LoadEnv() error {
 	}
 
 	err := godotenv.Load(envFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
-		log.Printf("Error loading .env file for %s environment: %v", env, err)
+		slog.Error("Error loading .env file", "environment", env, "file", envFile, "error", err)
 		return err
 	}
 
 	return nil
 }
-
-// GetEnv retrieves the value of the environment variable named by the key.
-func GetEnv(key string) string {
-	return os.Getenv(key)
-}
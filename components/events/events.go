@@ -0,0 +1,242 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"GOLANG_SERVER/components/logging"
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// Types of events
+const (
+	TypeReading = "reading"
+	TypeStatus  = "status"
+	TypeAlert   = "alert"
+)
+
+// How many of the latest events are at least kept for subscribers resuming after a reconnect
+const bufferSize = 10000
+
+// How many events a subscriber may fall behind before it is dropped; it resumes from the buffer
+const subscriberBuffer = 256
+
+// How long an offline device is remembered; anyone can send readings of made-up devices on the open channels
+const forgetAfter = 24 * time.Hour
+
+var logger = logging.For("events")
+
+// Event is something that happened to a device, delivered to the subscribers of its organization.
+// ID is "<sequence>-<unix ms>"; sequences keep growing across restarts, they start at the boot time in µs.
+type Event struct {
+	ID           string
+	Type         string
+	Organization string
+	Device       string
+	Time         time.Time
+	Data         any
+
+	sequence uint64
+}
+
+// Status tells whether a device is sending readings
+type Status struct {
+	DeviceAddress string `json:"DeviceAddress"`
+	Online        bool   `json:"Online"`
+	LastSeen      string `json:"LastSeen"`
+}
+
+// Alert is a reading of a device that was rejected
+type Alert struct {
+	DeviceAddress string `json:"DeviceAddress"`
+	Source        string `json:"Source"`
+	Reason        string `json:"Reason"`
+	DateTime      string `json:"DateTime"`
+}
+
+// Subscription receives the events of an organization's devices until it is closed. C is closed when the
+// subscriber fell too far behind; it should reconnect and resume after the last event it received.
+type Subscription struct {
+	C <-chan Event
+
+	events       chan Event
+	organization string
+	devices      []string
+}
+
+type deviceState struct {
+	lastSeen time.Time
+	online   bool
+}
+
+var (
+	mutex       sync.Mutex
+	sequence    = uint64(time.Now().UnixMicro())
+	buffer      []Event
+	subscribers = make(map[*Subscription]bool)
+	devices     = make(map[[2]string]*deviceState)
+)
+
+// Reading publishes a stored reading, and the device coming online if it was not
+func Reading(data schema.GyroData) {
+	at := time.UnixMilli(data.TimeStamp)
+	key := [2]string{data.Organization, data.DeviceAddress}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	state, known := devices[key]
+	if !known {
+		state = &deviceState{}
+		devices[key] = state
+	}
+	state.lastSeen = at
+	if !state.online {
+		state.online = true
+		publish(Event{Type: TypeStatus, Organization: data.Organization, Device: data.DeviceAddress, Time: at, Data: state.status(data.DeviceAddress)})
+	}
+	publish(Event{Type: TypeReading, Organization: data.Organization, Device: data.DeviceAddress, Time: at, Data: data})
+}
+
+// Rejected publishes an alert about a reading of a device that failed validation
+func Rejected(organization string, device string, source string, reason string) {
+	at := time.Now()
+	alert := Alert{DeviceAddress: device, Source: source, Reason: reason, DateTime: at.Format(time.RFC3339)}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	publish(Event{Type: TypeAlert, Organization: organization, Device: device, Time: at, Data: alert})
+}
+
+// Watch publishes a device going offline once it sent no reading for offlineAfter, and forgets it
+// after forgetAfter, until ctx is done
+func Watch(ctx context.Context, offlineAfter time.Duration) {
+	ticker := time.NewTicker(max(offlineAfter/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			mutex.Lock()
+			for key, state := range devices {
+				if state.online && now.Sub(state.lastSeen) > offlineAfter {
+					state.online = false
+					publish(Event{Type: TypeStatus, Organization: key[0], Device: key[1], Time: now, Data: state.status(key[1])})
+				}
+				if !state.online && now.Sub(state.lastSeen) > forgetAfter {
+					delete(devices, key)
+				}
+			}
+			mutex.Unlock()
+		}
+	}
+}
+
+// Statuses returns the status of the devices of an organization seen in the last forgetAfter,
+// of every one if devices is empty
+func Statuses(organization string, deviceAddresses []string) []Status {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var statuses []Status
+	for key, state := range devices {
+		if key[0] == organization && (len(deviceAddresses) == 0 || slices.Contains(deviceAddresses, key[1])) {
+			statuses = append(statuses, state.status(key[1]))
+		}
+	}
+	slices.SortFunc(statuses, func(a, b Status) int { return strings.Compare(a.DeviceAddress, b.DeviceAddress) })
+	return statuses
+}
+
+// Subscribe starts delivering the events of an organization's devices, of every one if devices is empty.
+// With the ID of the last event a subscriber received, the events after it are returned; resumed is false
+// if they are no longer kept, the caller then has to catch up another way.
+func Subscribe(organization string, deviceAddresses []string, lastEventID string) (subscription *Subscription, missed []Event, resumed bool) {
+	events := make(chan Event, subscriberBuffer)
+	subscription = &Subscription{C: events, events: events, organization: organization, devices: deviceAddresses}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	subscribers[subscription] = true
+	if lastEventID == "" {
+		return subscription, nil, true
+	}
+
+	last, _, err := ParseID(lastEventID)
+	if err != nil || len(buffer) == 0 || last+1 < buffer[0].sequence || last > sequence {
+		return subscription, nil, false
+	}
+	// * sequences in the buffer have no holes, the event after the last one is found by its number
+	for _, event := range buffer[last+1-buffer[0].sequence:] {
+		if subscription.wants(event) {
+			missed = append(missed, event)
+		}
+	}
+	return subscription, missed, true
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if subscribers[s] {
+		delete(subscribers, s)
+		close(s.events)
+	}
+}
+
+// ParseID returns the sequence and time of an event ID
+func ParseID(id string) (uint64, time.Time, error) {
+	sequencePart, milliseconds, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("invalid event ID %q", id)
+	}
+	number, err := strconv.ParseUint(sequencePart, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid event ID %q", id)
+	}
+	at, err := strconv.ParseInt(milliseconds, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid event ID %q", id)
+	}
+	return number, time.UnixMilli(at), nil
+}
+
+func (s *Subscription) wants(event Event) bool {
+	return event.Organization == s.organization && (len(s.devices) == 0 || slices.Contains(s.devices, event.Device))
+}
+
+func (d *deviceState) status(deviceAddress string) Status {
+	return Status{DeviceAddress: deviceAddress, Online: d.online, LastSeen: d.lastSeen.Format(time.RFC3339)}
+}
+
+// publish numbers an event, keeps it and hands it to the subscribers; the caller holds mutex
+func publish(event Event) {
+	sequence++
+	event.sequence = sequence
+	event.ID = strconv.FormatUint(sequence, 10) + "-" + strconv.FormatInt(event.Time.UnixMilli(), 10)
+
+	// * the older half is dropped at once, rather than one event on every publish
+	if len(buffer) == 2*bufferSize {
+		buffer = append(buffer[:0], buffer[bufferSize:]...)
+	}
+	buffer = append(buffer, event)
+
+	for subscription := range subscribers {
+		if !subscription.wants(event) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			// * a slow subscriber is dropped rather than slowing down ingestion, it resumes from the buffer
+			logger.Debug("Subscriber fell behind, dropping it", "organization", subscription.organization)
+			delete(subscribers, subscription)
+			close(subscription.events)
+		}
+	}
+}
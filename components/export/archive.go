@@ -0,0 +1,117 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/logging"
+)
+
+var logger = logging.For("export")
+
+// ArchiveOptions selects the readings Archive writes. From and Before are UTC days, Before
+// is not included; Organization and Device are every one if empty.
+type ArchiveOptions struct {
+	Dir          string
+	Organization string
+	Device       string
+	From         time.Time
+	Before       time.Time
+	// Prune deletes the readings written to a partition's file from the database once the file is complete
+	Prune bool
+}
+
+// Archive writes readings into Parquet files under
+// Dir/organization=<slug>/device=<address>/date=<day>/part-<run>.parquet. Every run writes
+// new files, so running it again without Prune archives the same readings twice.
+func Archive(ctx context.Context, options ArchiveOptions) error {
+	organizations := []string{options.Organization}
+	if options.Organization == "" {
+		list, err := db.GetOrganizations()
+		if err != nil {
+			return err
+		}
+		organizations = organizations[:0]
+		for _, organization := range list {
+			organizations = append(organizations, organization.Slug)
+		}
+	}
+
+	run := time.Now().UTC().Format("20060102T150405Z")
+	filter := db.ReadingFilter{DeviceAddress: options.Device, ByDevice: true}
+	if !options.From.IsZero() {
+		filter.From = options.From.UnixMilli()
+	}
+	if !options.Before.IsZero() {
+		filter.To = options.Before.UnixMilli() - 1
+	}
+
+	for _, organization := range organizations {
+		dir := filepath.Join(options.Dir, "organization="+organization)
+		partitioner := &Partitioner{
+			Open: func(partition Partition) (io.WriteCloser, error) {
+				return createFile(filepath.Join(dir, filepath.FromSlash(partition.Path()), "part-"+run+".parquet"))
+			},
+			Closed: func(partition Partition) error {
+				logger.Info("Partition archived", "organization", organization, "device", partition.Device,
+					"date", partition.Day.Format(time.DateOnly), "rows", partition.Rows)
+				if !options.Prune {
+					return nil
+				}
+				// * by ID, readings stored into the day while it was archived are not in the file and stay
+				deleted, err := db.DeleteGyroData(organization, partition.IDs)
+				if err != nil {
+					return fmt.Errorf("pruning %s: %w", partition.Path(), err)
+				}
+				if deleted != partition.Rows {
+					logger.Warn("Archived readings were already deleted", "organization", organization,
+						"device", partition.Device, "date", partition.Day.Format(time.DateOnly), "archived", partition.Rows, "deleted", deleted)
+				}
+				return nil
+			},
+		}
+
+		err := db.EachGyroData(ctx, organization, filter, partitioner.Write)
+		if closeErr := partitioner.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("organization %s: %w", organization, err)
+		}
+	}
+	return nil
+}
+
+// archiveFile is written under a temporary name and only renamed once it is complete and on disk,
+// a partition is never pruned while its file could still be lost
+type archiveFile struct {
+	*os.File
+	path string
+}
+
+func createFile(path string) (*archiveFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &archiveFile{File: file, path: path}, nil
+}
+
+func (a *archiveFile) Close() error {
+	if err := a.File.Sync(); err != nil {
+		a.File.Close()
+		return err
+	}
+	if err := a.File.Close(); err != nil {
+		return err
+	}
+	return os.Rename(a.File.Name(), a.path)
+}
@@ -0,0 +1,68 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spreadsheets read this layout as a date and time, unlike RFC 3339 with its offset
+const csvTimeLayout = "2006-01-02 15:04:05.000"
+
+type csvWriter struct {
+	writer *csv.Writer
+	record []string
+}
+
+func newCSV(w io.Writer) *csvWriter {
+	return &csvWriter{writer: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) Header(columns []Column) error {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	return c.writer.Write(names)
+}
+
+func (c *csvWriter) Row(values []any) error {
+	c.record = c.record[:0]
+	for _, value := range values {
+		c.record = append(c.record, formatValue(value))
+	}
+	return c.writer.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// Cell keeps a text cell from being read as a formula by spreadsheets, e.g. "=HYPERLINK(...)" sent by a
+// gateway as its address, by putting a quote in front of the characters a formula can start with
+func Cell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return Cell(v)
+	case time.Time:
+		return v.Format(csvTimeLayout)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float32:
+		// * formatted as float32, 0.1 would be 0.10000000149011612 as float64
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
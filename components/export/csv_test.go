@@ -0,0 +1,35 @@
+package export
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVEscapesFormulas(t *testing.T) {
+	var out strings.Builder
+	writer := newCSV(&out)
+	rows := [][]any{
+		{"=HYPERLINK(\"http://evil\")", int64(-5), float32(-0.5)},
+		{"+1", "-1", "@SUM(A1)"},
+		{"\tcmd", "\rcmd", "gyro-1"},
+		{"", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), true},
+	}
+	for _, row := range rows {
+		if err := writer.Row(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// * numbers stay numbers, only text is quoted
+	want := "\"'=HYPERLINK(\"\"http://evil\"\")\",-5,-0.5\n" +
+		"'+1,'-1,'@SUM(A1)\n" +
+		"'\tcmd,\"'\rcmd\",gyro-1\n" +
+		",2024-03-01 12:00:00.000,true\n"
+	if out.String() != want {
+		t.Fatalf("csv =\n%q\nwant\n%q", out.String(), want)
+	}
+}
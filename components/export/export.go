@@ -0,0 +1,149 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// Formats of an export
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// Column is one flattened field of a reading, named by its path such as "X.VibrationSpeed"
+type Column struct {
+	Name  string
+	value func(data schema.GyroData, location *time.Location) any
+}
+
+// Writer writes readings row by row as they are read, nothing is kept besides the current row
+type Writer interface {
+	Header(columns []Column) error
+	Row(values []any) error
+	// Close writes what the format needs at the end; the output is incomplete without it
+	Close() error
+}
+
+// Columns are every column of a reading, in the order they are exported by default
+var Columns = columns()
+
+// ContentType returns the media type and file extension of a format
+func ContentType(format string) (string, string) {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"
+	}
+	return "text/csv; charset=utf-8", ".csv"
+}
+
+// New returns the writer of a format
+func New(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV, "":
+		return newCSV(w), nil
+	case FormatXLSX:
+		return newXLSX(w), nil
+	}
+	return nil, fmt.Errorf("unknown format %q, use csv or xlsx", format)
+}
+
+// Select returns the named columns in the given order, every column without names
+func Select(names []string) ([]Column, error) {
+	if len(names) == 0 {
+		return Columns, nil
+	}
+	selected := make([]Column, 0, len(names))
+	for _, name := range names {
+		column, ok := columnNamed(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		selected = append(selected, column)
+	}
+	return selected, nil
+}
+
+// Row returns the values of the columns for a reading. DateTime is a time in the location.
+func Row(columns []Column, data schema.GyroData, location *time.Location) []any {
+	values := make([]any, len(columns))
+	for i, column := range columns {
+		values[i] = column.value(data, location)
+	}
+	return values
+}
+
+func columnNamed(name string) (Column, bool) {
+	for _, column := range Columns {
+		if strings.EqualFold(column.Name, name) {
+			return column, true
+		}
+	}
+	return Column{}, false
+}
+
+// timeOf returns when a reading was taken; readings stored before TimeStamp existed only have DateTime
+func timeOf(data schema.GyroData, location *time.Location) any {
+	if data.TimeStamp == 0 {
+		parsed, err := time.Parse(time.RFC3339, data.DateTime)
+		if err != nil {
+			return data.DateTime
+		}
+		return parsed.In(location)
+	}
+	return time.UnixMilli(data.TimeStamp).In(location)
+}
+
+func columns() []Column {
+	list := []Column{
+		{"DeviceAddress", func(data schema.GyroData, _ *time.Location) any { return data.DeviceAddress }},
+		{"DateTime", timeOf},
+		{"TimeStamp", func(data schema.GyroData, _ *time.Location) any { return data.TimeStamp }},
+	}
+
+	axes := []struct {
+		name string
+		axis func(schema.GyroData) schema.GyroStruct
+	}{
+		{"X", func(data schema.GyroData) schema.GyroStruct { return data.X }},
+		{"Y", func(data schema.GyroData) schema.GyroStruct { return data.Y }},
+		{"Z", func(data schema.GyroData) schema.GyroStruct { return data.Z }},
+	}
+	fields := []struct {
+		name  string
+		field func(schema.GyroStruct) float32
+	}{
+		{"Acceleration", func(axis schema.GyroStruct) float32 { return axis.Acceleration }},
+		{"VelocityAngular", func(axis schema.GyroStruct) float32 { return axis.VelocityAngular }},
+		{"VibrationSpeed", func(axis schema.GyroStruct) float32 { return axis.VibrationSpeed }},
+		{"VibrationAngle", func(axis schema.GyroStruct) float32 { return axis.VibrationAngle }},
+		{"VibrationDisplacement", func(axis schema.GyroStruct) float32 { return axis.VibrationDisplacement }},
+		{"VibrationDisplacementHighSpeed", func(axis schema.GyroStruct) float32 { return axis.VibrationDisplacementHighSpeed }},
+		{"Frequency", func(axis schema.GyroStruct) float32 { return axis.Frequency }},
+	}
+	for _, axis := range axes {
+		for _, field := range fields {
+			axis, field := axis, field
+			list = append(list, Column{axis.name + "." + field.name, func(data schema.GyroData, _ *time.Location) any {
+				return field.field(axis.axis(data))
+			}})
+		}
+	}
+
+	return append(list,
+		Column{"Temperature", func(data schema.GyroData, _ *time.Location) any { return data.Temperature }},
+		Column{"ModbusHighSpeed", func(data schema.GyroData, _ *time.Location) any { return data.ModbusHighSpeed }},
+		Column{"MessageID", func(data schema.GyroData, _ *time.Location) any { return data.MessageID }},
+		Column{"BootID", func(data schema.GyroData, _ *time.Location) any { return data.BootID }},
+		Column{"Sequence", func(data schema.GyroData, _ *time.Location) any {
+			if data.Sequence == nil {
+				return nil
+			}
+			return *data.Sequence
+		}},
+		Column{"DeviceTimeStamp", func(data schema.GyroData, _ *time.Location) any { return data.DeviceTimeStamp }},
+	)
+}
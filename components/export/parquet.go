@@ -0,0 +1,415 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// * a Parquet file is "PAR1", row groups holding one column chunk per column, and a footer
+// * describing the schema and where every chunk is. Each chunk here is a single data page,
+// * PLAIN encoded and gzip compressed.
+
+// ParquetSchemaVersion changes only when a column of the Parquet files changes; it is stored in every file
+const ParquetSchemaVersion = "1"
+
+// Readings of a row group are kept in memory until it is written
+const rowGroupRows = 64 * 1024
+
+const parquetMagic = "PAR1"
+
+// Parquet physical types, repetitions, converted types, encodings and codecs used
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	convertedNone            = -1
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecGzip = 2
+)
+
+// parquetField is a node of the schema: a column, or a group of columns such as X
+type parquetField struct {
+	name      string
+	kind      int32
+	converted int32
+	optional  bool
+	fields    []parquetField
+	// * the value of a column for a reading, nil for a missing optional value
+	value func(schema.GyroData) any
+}
+
+// parquetSchema mirrors schema.GyroData; the fields left out of JSON when empty are optional
+var parquetSchema = gyroSchema()
+
+// ParquetWriter writes readings into a Parquet file, one row group at a time
+type ParquetWriter struct {
+	w         *countingWriter
+	columns   []*parquetColumn
+	rows      int64
+	rowGroups []parquetRowGroup
+	gzip      *gzip.Writer
+	err       error
+}
+
+// parquetColumn buffers the values of a column for the current row group
+type parquetColumn struct {
+	path     []string
+	kind     int32
+	optional bool
+	value    func(schema.GyroData) any
+
+	values bytes.Buffer
+	bits   []bool
+	levels []byte
+	count  int64
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	size   int64
+	chunks []parquetChunk
+}
+
+type parquetChunk struct {
+	offset       int64
+	values       int64
+	uncompressed int64
+	compressed   int64
+}
+
+// NewParquet starts a Parquet file of readings
+func NewParquet(w io.Writer) *ParquetWriter {
+	writer := &ParquetWriter{w: &countingWriter{w: w}, gzip: gzip.NewWriter(nil)}
+	var walk func(fields []parquetField, path []string)
+	walk = func(fields []parquetField, path []string) {
+		for _, field := range fields {
+			fieldPath := append(append([]string{}, path...), field.name)
+			if field.fields != nil {
+				walk(field.fields, fieldPath)
+				continue
+			}
+			writer.columns = append(writer.columns, &parquetColumn{path: fieldPath, kind: field.kind, optional: field.optional, value: field.value})
+		}
+	}
+	walk(parquetSchema, nil)
+
+	_, writer.err = io.WriteString(writer.w, parquetMagic)
+	return writer
+}
+
+// Write adds a reading, writing a row group when it is full
+func (p *ParquetWriter) Write(data schema.GyroData) error {
+	if p.err != nil {
+		return p.err
+	}
+	for _, column := range p.columns {
+		column.add(column.value(data))
+	}
+	p.rows++
+	if p.columns[0].count == rowGroupRows {
+		p.err = p.flush()
+	}
+	return p.err
+}
+
+// Close writes the last row group and the footer
+func (p *ParquetWriter) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.columns[0].count > 0 {
+		if p.err = p.flush(); p.err != nil {
+			return p.err
+		}
+	}
+
+	footer := p.footer()
+	length := binary.LittleEndian.AppendUint32(nil, uint32(len(footer)))
+	for _, part := range [][]byte{footer, length, []byte(parquetMagic)} {
+		if _, err := p.w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush writes the buffered values as a row group, one page per column
+func (p *ParquetWriter) flush() error {
+	group := parquetRowGroup{rows: p.columns[0].count}
+	var page bytes.Buffer
+	var compressed bytes.Buffer
+
+	for _, column := range p.columns {
+		page.Reset()
+		column.page(&page)
+
+		compressed.Reset()
+		p.gzip.Reset(&compressed)
+		p.gzip.Write(page.Bytes())
+		if err := p.gzip.Close(); err != nil {
+			return err
+		}
+
+		header := newThriftWriter()
+		header.i32(1, 0) // * data page
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(compressed.Len()))
+		header.beginStruct(5)
+		header.i32(1, int32(column.count))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.end()
+		header.end()
+
+		chunk := parquetChunk{
+			offset:       p.w.n,
+			values:       column.count,
+			uncompressed: int64(len(header.bytes()) + page.Len()),
+			compressed:   int64(len(header.bytes()) + compressed.Len()),
+		}
+		if _, err := p.w.Write(header.bytes()); err != nil {
+			return err
+		}
+		if _, err := p.w.Write(compressed.Bytes()); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.uncompressed
+		column.reset()
+	}
+
+	p.rowGroups = append(p.rowGroups, group)
+	return nil
+}
+
+// footer is the FileMetaData: the schema, the row groups and where their chunks are
+func (p *ParquetWriter) footer() []byte {
+	var elements int
+	var count func(fields []parquetField)
+	count = func(fields []parquetField) {
+		for _, field := range fields {
+			elements++
+			count(field.fields)
+		}
+	}
+	count(parquetSchema)
+
+	meta := newThriftWriter()
+	meta.i32(1, 1)
+	meta.beginList(2, thriftStruct, elements+1)
+	meta.beginElement()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(parquetSchema)))
+	meta.end()
+	var describe func(fields []parquetField)
+	describe = func(fields []parquetField) {
+		for _, field := range fields {
+			meta.beginElement()
+			if field.fields == nil {
+				meta.i32(1, field.kind)
+			}
+			if field.optional {
+				meta.i32(3, parquetOptional)
+			} else {
+				meta.i32(3, parquetRequired)
+			}
+			meta.binary(4, field.name)
+			if field.fields != nil {
+				meta.i32(5, int32(len(field.fields)))
+			}
+			if field.converted != convertedNone {
+				meta.i32(6, field.converted)
+			}
+			meta.end()
+			describe(field.fields)
+		}
+	}
+	describe(parquetSchema)
+
+	meta.i64(3, p.rows)
+	meta.beginList(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		meta.beginElement()
+		meta.beginList(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			column := p.columns[i]
+			meta.beginElement()
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3)
+			meta.i32(1, column.kind)
+			meta.beginList(2, thriftI32, 2)
+			meta.elementI32(encodingPlain)
+			meta.elementI32(encodingRLE)
+			meta.beginList(3, thriftBinary, len(column.path))
+			for _, name := range column.path {
+				meta.element(name)
+			}
+			meta.i32(4, codecGzip)
+			meta.i64(5, chunk.values)
+			meta.i64(6, chunk.uncompressed)
+			meta.i64(7, chunk.compressed)
+			meta.i64(9, chunk.offset)
+			meta.end()
+			meta.end()
+		}
+		meta.i64(2, group.size)
+		meta.i64(3, group.rows)
+		meta.end()
+	}
+
+	meta.beginList(5, thriftStruct, 1)
+	meta.beginElement()
+	meta.binary(1, "gyro.schema_version")
+	meta.binary(2, ParquetSchemaVersion)
+	meta.end()
+	meta.binary(6, "gyro-server")
+	meta.end()
+	return meta.bytes()
+}
+
+func (c *parquetColumn) add(value any) {
+	c.count++
+	if c.optional {
+		if value == nil {
+			c.levels = append(c.levels, 0)
+			return
+		}
+		c.levels = append(c.levels, 1)
+	}
+
+	switch v := value.(type) {
+	case string:
+		c.values.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(v))))
+		c.values.WriteString(v)
+	case int64:
+		c.values.Write(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+	case float32:
+		c.values.Write(binary.LittleEndian.AppendUint32(nil, math.Float32bits(v)))
+	case bool:
+		c.bits = append(c.bits, v)
+	}
+}
+
+// page writes the definition levels of an optional column, then its values
+func (c *parquetColumn) page(page *bytes.Buffer) {
+	if c.optional {
+		levels := encodeLevels(c.levels)
+		page.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(levels))))
+		page.Write(levels)
+	}
+	if c.kind == parquetBoolean {
+		// * booleans are bit-packed, the first value in the lowest bit
+		packed := make([]byte, (len(c.bits)+7)/8)
+		for i, bit := range c.bits {
+			if bit {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		page.Write(packed)
+		return
+	}
+	page.Write(c.values.Bytes())
+}
+
+func (c *parquetColumn) reset() {
+	c.values.Reset()
+	c.bits = c.bits[:0]
+	c.levels = c.levels[:0]
+	c.count = 0
+}
+
+// encodeLevels writes definition levels of bit width 1 as a single bit-packed run of the
+// RLE/bit-packing hybrid encoding, padded to a multiple of 8 levels
+func encodeLevels(levels []byte) []byte {
+	packed := make([]byte, (len(levels)+7)/8)
+	for i, level := range levels {
+		packed[i/8] |= level << (i % 8)
+	}
+	return append(binary.AppendUvarint(nil, uint64(len(packed))<<1|1), packed...)
+}
+
+// countingWriter knows the offset in the file, the footer points at every chunk
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(data []byte) (int, error) {
+	n, err := c.w.Write(data)
+	c.n += int64(n)
+	return n, err
+}
+
+func gyroSchema() []parquetField {
+	text := func(name string, value func(schema.GyroData) string) parquetField {
+		return parquetField{name: name, kind: parquetByteArray, converted: convertedUTF8, value: func(data schema.GyroData) any { return value(data) }}
+	}
+	number := func(name string, value func(schema.GyroData) float32) parquetField {
+		return parquetField{name: name, kind: parquetFloat, converted: convertedNone, value: func(data schema.GyroData) any { return value(data) }}
+	}
+	axis := func(name string, axis func(schema.GyroData) schema.GyroStruct) parquetField {
+		return parquetField{name: name, converted: convertedNone, fields: []parquetField{
+			number("Acceleration", func(data schema.GyroData) float32 { return axis(data).Acceleration }),
+			number("VelocityAngular", func(data schema.GyroData) float32 { return axis(data).VelocityAngular }),
+			number("VibrationSpeed", func(data schema.GyroData) float32 { return axis(data).VibrationSpeed }),
+			number("VibrationAngle", func(data schema.GyroData) float32 { return axis(data).VibrationAngle }),
+			number("VibrationDisplacement", func(data schema.GyroData) float32 { return axis(data).VibrationDisplacement }),
+			number("VibrationDisplacementHighSpeed", func(data schema.GyroData) float32 { return axis(data).VibrationDisplacementHighSpeed }),
+			number("Frequency", func(data schema.GyroData) float32 { return axis(data).Frequency }),
+		}}
+	}
+	optional := func(name string, kind int32, converted int32, value func(schema.GyroData) any) parquetField {
+		return parquetField{name: name, kind: kind, converted: converted, optional: true, value: value}
+	}
+
+	return []parquetField{
+		text("DeviceAddress", func(data schema.GyroData) string { return data.DeviceAddress }),
+		text("DateTime", func(data schema.GyroData) string { return data.DateTime }),
+		{name: "TimeStamp", kind: parquetInt64, converted: convertedTimestampMillis, value: func(data schema.GyroData) any { return data.TimeStamp }},
+		axis("X", func(data schema.GyroData) schema.GyroStruct { return data.X }),
+		axis("Y", func(data schema.GyroData) schema.GyroStruct { return data.Y }),
+		axis("Z", func(data schema.GyroData) schema.GyroStruct { return data.Z }),
+		number("Temperature", func(data schema.GyroData) float32 { return data.Temperature }),
+		{name: "ModbusHighSpeed", kind: parquetBoolean, converted: convertedNone, value: func(data schema.GyroData) any { return data.ModbusHighSpeed }},
+		optional("MessageID", parquetByteArray, convertedUTF8, func(data schema.GyroData) any {
+			if data.MessageID == "" {
+				return nil
+			}
+			return data.MessageID
+		}),
+		optional("BootID", parquetByteArray, convertedUTF8, func(data schema.GyroData) any {
+			if data.BootID == "" {
+				return nil
+			}
+			return data.BootID
+		}),
+		optional("Sequence", parquetInt64, convertedNone, func(data schema.GyroData) any {
+			if data.Sequence == nil {
+				return nil
+			}
+			return *data.Sequence
+		}),
+		optional("DeviceTimeStamp", parquetInt64, convertedNone, func(data schema.GyroData) any {
+			if data.DeviceTimeStamp == 0 {
+				return nil
+			}
+			return data.DeviceTimeStamp
+		}),
+	}
+}
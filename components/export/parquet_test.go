@@ -0,0 +1,305 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// * the file is read back by the reader below, written from the Parquet and Thrift specifications
+// * apart from the writer, so a mistake in one of them doesn't cancel out
+
+// thriftReader decodes the Thrift compact protocol into maps of field id to value
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (t *thriftReader) byte() byte {
+	b := t.data[t.pos]
+	t.pos++
+	return b
+}
+
+func (t *thriftReader) uvarint() uint64 {
+	value, n := binary.Uvarint(t.data[t.pos:])
+	if n <= 0 {
+		panic("bad varint")
+	}
+	t.pos += n
+	return value
+}
+
+func (t *thriftReader) varint() int64 {
+	value := t.uvarint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (t *thriftReader) structure() map[int16]any {
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		header := t.byte()
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(t.varint())
+		}
+		fields[id] = t.value(header & 0x0f)
+		last = id
+	}
+}
+
+func (t *thriftReader) value(kind byte) any {
+	switch kind {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 5, 6:
+		return t.varint()
+	case 8:
+		size := int(t.uvarint())
+		t.pos += size
+		return string(t.data[t.pos-size : t.pos])
+	case 9:
+		header := t.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(t.uvarint())
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = t.value(header & 0x0f)
+		}
+		return list
+	case 12:
+		return t.structure()
+	}
+	panic(fmt.Sprintf("thrift type %d is not expected in these files", kind))
+}
+
+// readParquet reads every reading of a file written by ParquetWriter, and its footer
+func readParquet(t *testing.T, file []byte) ([]schema.GyroData, map[int16]any) {
+	t.Helper()
+	if !bytes.HasPrefix(file, []byte(parquetMagic)) || !bytes.HasSuffix(file, []byte(parquetMagic)) {
+		t.Fatal("file does not start and end with PAR1")
+	}
+	length := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := &thriftReader{data: file[len(file)-8-length : len(file)-8]}
+	meta := footer.structure()
+	if footer.pos != length {
+		t.Fatalf("footer is %d bytes, %d were decoded", length, footer.pos)
+	}
+
+	// * the leaf columns in schema order, with whether they are optional
+	elements := meta[2].([]any)
+	optional := make(map[string]bool)
+	var walk func(index int, path []string) int
+	walk = func(index int, path []string) int {
+		element := elements[index].(map[int16]any)
+		children, _ := element[5].(int64)
+		if index > 0 {
+			path = append(path, element[4].(string))
+		}
+		next := index + 1
+		for range children {
+			next = walk(next, path)
+		}
+		if children == 0 {
+			optional[strings.Join(path, ".")] = element[3].(int64) == parquetOptional
+		}
+		return next
+	}
+	if walk(0, nil) != len(elements) {
+		t.Fatal("schema elements left over")
+	}
+
+	var readings []schema.GyroData
+	for _, group := range meta[4].([]any) {
+		group := group.(map[int16]any)
+		rows := make([]schema.GyroData, group[3].(int64))
+		for _, chunk := range group[1].([]any) {
+			column := chunk.(map[int16]any)[3].(map[int16]any)
+			var path []string
+			for _, name := range column[3].([]any) {
+				path = append(path, name.(string))
+			}
+			name := strings.Join(path, ".")
+
+			page := &thriftReader{data: file, pos: int(column[9].(int64))}
+			header := page.structure()
+			compressed := file[page.pos : page.pos+int(header[3].(int64))]
+			decompressor, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			values, err := io.ReadAll(decompressor)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if len(values) != int(header[2].(int64)) {
+				t.Fatalf("%s: page is %d bytes, the header says %d", name, len(values), header[2])
+			}
+			if count := header[5].(map[int16]any)[1].(int64); count != int64(len(rows)) || column[5].(int64) != count {
+				t.Fatalf("%s: %d values, want one for each of the %d rows", name, count, len(rows))
+			}
+
+			present := make([]bool, len(rows))
+			for i := range present {
+				present[i] = true
+			}
+			if optional[name] {
+				size := int(binary.LittleEndian.Uint32(values))
+				decodeLevels(t, values[4:4+size], present)
+				values = values[4+size:]
+			}
+			decodeColumn(t, column[1].(int64), values, present, rows, path)
+		}
+		readings = append(readings, rows...)
+	}
+	return readings, meta
+}
+
+// decodeLevels reads definition levels of bit width 1 in the RLE/bit-packing hybrid encoding
+func decodeLevels(t *testing.T, data []byte, present []bool) {
+	t.Helper()
+	levels := &thriftReader{data: data}
+	for i := 0; i < len(present); {
+		header := levels.uvarint()
+		if header&1 == 0 {
+			value := levels.byte() == 1
+			for run := 0; run < int(header>>1) && i < len(present); run++ {
+				present[i] = value
+				i++
+			}
+			continue
+		}
+		for bit := 0; bit < int(header>>1)*8; bit++ {
+			if bit%8 == 0 {
+				levels.byte()
+			}
+			if i < len(present) {
+				present[i] = data[levels.pos-1]>>(bit%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// decodeColumn reads PLAIN encoded values into the field at path of every row that has one
+func decodeColumn(t *testing.T, kind int64, values []byte, present []bool, rows []schema.GyroData, path []string) {
+	t.Helper()
+	var bit int
+	for i := range rows {
+		if !present[i] {
+			continue
+		}
+		field := reflect.ValueOf(&rows[i]).Elem()
+		for _, name := range path {
+			field = field.FieldByName(name)
+		}
+		if field.Kind() == reflect.Pointer {
+			field.Set(reflect.New(field.Type().Elem()))
+			field = field.Elem()
+		}
+
+		switch kind {
+		case parquetBoolean:
+			field.SetBool(values[bit/8]>>(bit%8)&1 == 1)
+			bit++
+		case parquetInt64:
+			field.SetInt(int64(binary.LittleEndian.Uint64(values)))
+			values = values[8:]
+		case parquetFloat:
+			field.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(values))))
+			values = values[4:]
+		case parquetByteArray:
+			size := binary.LittleEndian.Uint32(values)
+			field.SetString(string(values[4 : 4+size]))
+			values = values[4+size:]
+		default:
+			t.Fatalf("%v: unexpected type %d", path, kind)
+		}
+	}
+	if kind != parquetBoolean && len(values) != 0 {
+		t.Fatalf("%v: %d bytes left after the values", path, len(values))
+	}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	// * more than a row group, every optional column both set and missing
+	readings := make([]schema.GyroData, rowGroupRows+17)
+	for i := range readings {
+		data := schema.GyroData{
+			DeviceAddress:   fmt.Sprintf("gyro-%d", i%3),
+			DateTime:        "2024-01-31 10:00:00",
+			TimeStamp:       1706695200000 + int64(i),
+			X:               schema.GyroStruct{Acceleration: float32(i) / 10, Frequency: 50.5},
+			Y:               schema.GyroStruct{VelocityAngular: -float32(i), VibrationDisplacementHighSpeed: math.MaxFloat32},
+			Z:               schema.GyroStruct{VibrationSpeed: 0.1, VibrationAngle: -0.25, VibrationDisplacement: float32(i % 7)},
+			Temperature:     -2.5,
+			ModbusHighSpeed: i%3 == 1,
+		}
+		if i%2 == 0 {
+			data.MessageID = fmt.Sprintf("m-%d", i)
+		}
+		if i%5 != 0 {
+			sequence := int64(i % 4)
+			data.BootID, data.Sequence = "boot-1", &sequence
+		}
+		if i%7 == 3 {
+			data.DeviceTimeStamp = data.TimeStamp - 40
+		}
+		readings[i] = data
+	}
+
+	var file bytes.Buffer
+	writer := NewParquet(&file)
+	for _, data := range readings {
+		if err := writer.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, meta := readParquet(t, file.Bytes())
+	if meta[3].(int64) != int64(len(readings)) || len(meta[4].([]any)) != 2 {
+		t.Fatalf("footer has %d rows in %d row groups, want %d in 2", meta[3], len(meta[4].([]any)), len(readings))
+	}
+	version := meta[5].([]any)[0].(map[int16]any)
+	if version[1] != "gyro.schema_version" || version[2] != ParquetSchemaVersion {
+		t.Errorf("key value metadata = %v, want the schema version", version)
+	}
+	if len(read) != len(readings) {
+		t.Fatalf("read %d readings, wrote %d", len(read), len(readings))
+	}
+	for i := range readings {
+		if !reflect.DeepEqual(read[i], readings[i]) {
+			t.Fatalf("reading %d =\n%+v\nwant\n%+v", i, read[i], readings[i])
+		}
+	}
+}
+
+func TestParquetEmpty(t *testing.T) {
+	var file bytes.Buffer
+	if err := NewParquet(&file).Close(); err != nil {
+		t.Fatal(err)
+	}
+	read, meta := readParquet(t, file.Bytes())
+	if len(read) != 0 || meta[3].(int64) != 0 {
+		t.Fatalf("empty file has %d readings, %d rows", len(read), meta[3])
+	}
+}
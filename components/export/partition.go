@@ -0,0 +1,82 @@
+package export
+
+import (
+	"io"
+	"net/url"
+	"time"
+
+	schema "GOLANG_SERVER/components/schema"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Partition is the Parquet file of the readings of one device on one day (UTC)
+type Partition struct {
+	Device string
+	Day    time.Time
+	Rows   int64
+	// IDs of the readings written to the file
+	IDs []primitive.ObjectID
+}
+
+// Path is the directory of the partition, Hive style ("device=a1/date=2024-01-31") so that
+// pyarrow, pandas and Spark read the device and date back as columns
+func (p Partition) Path() string {
+	return "device=" + url.PathEscape(p.Device) + "/date=" + p.Day.Format(time.DateOnly)
+}
+
+// Partitioner writes readings sorted by device and time into one Parquet file per partition.
+// Open gives the file of a partition and Closed, if set, is told once it is complete.
+type Partitioner struct {
+	Open   func(Partition) (io.WriteCloser, error)
+	Closed func(Partition) error
+
+	current Partition
+	file    io.WriteCloser
+	writer  *ParquetWriter
+}
+
+// Write adds a reading to its partition, completing the previous partition when it changes
+func (p *Partitioner) Write(data schema.GyroData) error {
+	partition := Partition{Device: data.DeviceAddress, Day: dayOf(data)}
+	if p.writer == nil || partition.Device != p.current.Device || !partition.Day.Equal(p.current.Day) {
+		if err := p.Close(); err != nil {
+			return err
+		}
+		file, err := p.Open(partition)
+		if err != nil {
+			return err
+		}
+		p.current, p.file, p.writer = partition, file, NewParquet(file)
+	}
+
+	p.current.Rows++
+	p.current.IDs = append(p.current.IDs, data.ID)
+	return p.writer.Write(data)
+}
+
+// Close completes the partition being written
+func (p *Partitioner) Close() error {
+	if p.writer == nil {
+		return nil
+	}
+	writer, file := p.writer, p.file
+	p.writer, p.file = nil, nil
+
+	if err := writer.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if p.Closed != nil {
+		return p.Closed(p.current)
+	}
+	return nil
+}
+
+// dayOf is the UTC day of a reading's TimeStamp, the field its partition is selected by
+func dayOf(data schema.GyroData) time.Time {
+	return time.UnixMilli(data.TimeStamp).UTC().Truncate(24 * time.Hour)
+}
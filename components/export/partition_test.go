@@ -0,0 +1,58 @@
+package export
+
+import (
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	schema "GOLANG_SERVER/components/schema"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type discard struct{ io.Writer }
+
+func (discard) Close() error { return nil }
+
+func TestPartitionerKeepsWrittenIDs(t *testing.T) {
+	day := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	readings := []schema.GyroData{
+		{DeviceAddress: "a", TimeStamp: day.Add(time.Hour).UnixMilli()},
+		{DeviceAddress: "a", TimeStamp: day.Add(23 * time.Hour).UnixMilli()},
+		{DeviceAddress: "a", TimeStamp: day.AddDate(0, 0, 1).UnixMilli()},
+		{DeviceAddress: "b", TimeStamp: day.AddDate(0, 0, 1).UnixMilli()},
+	}
+	for i := range readings {
+		readings[i].ID = primitive.NewObjectID()
+	}
+
+	var closed []Partition
+	partitioner := &Partitioner{
+		Open:   func(Partition) (io.WriteCloser, error) { return discard{io.Discard}, nil },
+		Closed: func(partition Partition) error { closed = append(closed, partition); return nil },
+	}
+	for _, data := range readings {
+		if err := partitioner.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := partitioner.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// * pruning deletes exactly these, not whatever else is stored into the day meanwhile
+	want := [][]primitive.ObjectID{
+		{readings[0].ID, readings[1].ID},
+		{readings[2].ID},
+		{readings[3].ID},
+	}
+	if len(closed) != len(want) {
+		t.Fatalf("%d partitions, want %d", len(closed), len(want))
+	}
+	for i, partition := range closed {
+		if !slices.Equal(partition.IDs, want[i]) || partition.Rows != int64(len(want[i])) {
+			t.Errorf("partition %s has %d rows with IDs %v, want %v", partition.Path(), partition.Rows, partition.IDs, want[i])
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol, the encoding of Parquet's
+// page headers and footer. Only what the Parquet writer needs is implemented.
+type thriftWriter struct {
+	buf bytes.Buffer
+	// * the last field id of every open struct, ids are written as the difference to it
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (t *thriftWriter) i32(id int16, value int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(value)))
+}
+
+func (t *thriftWriter) i64(id int16, value int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(value))
+}
+
+func (t *thriftWriter) binary(id int16, value string) {
+	t.field(id, thriftBinary)
+	t.element(value)
+}
+
+// beginStruct starts a struct field, closed by end
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.last = append(t.last, 0)
+}
+
+// beginList starts a list field; its elements follow, structs started with beginElement
+func (t *thriftWriter) beginList(id int16, kind byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | kind)
+	} else {
+		t.buf.WriteByte(0xf0 | kind)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) beginElement() {
+	t.last = append(t.last, 0)
+}
+
+// end closes the innermost struct
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// elementI32 and element write list elements
+func (t *thriftWriter) elementI32(value int32) {
+	t.varint(zigzag(int64(value)))
+}
+
+func (t *thriftWriter) element(value string) {
+	t.varint(uint64(len(value)))
+	t.buf.WriteString(value)
+}
+
+func (t *thriftWriter) bytes() []byte {
+	return t.buf.Bytes()
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) varint(value uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, value))
+}
+
+func zigzag(value int64) uint64 {
+	return uint64(value<<1) ^ uint64(value>>63)
+}
@@ -0,0 +1,203 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// * an XLSX file is a zip of XML parts. The sheets are written into the zip as the rows come,
+// * the parts listing them are written last; a zip doesn't care about the order of its entries.
+
+// Excel's limit of rows per sheet, the header included; longer exports continue on another sheet
+const maxSheetRows = 1 << 20
+
+// Indexes of the cell formats in styles.xml
+const (
+	styleDateTime = 1
+	styleHeader   = 2
+)
+
+const spreadsheetNamespace = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+
+// Excel counts days from 1899-12-30
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+type xlsxWriter struct {
+	zip     *zip.Writer
+	created time.Time
+	sheet   *bufio.Writer
+	header  []Column
+	sheets  int
+	rows    int
+}
+
+func newXLSX(w io.Writer) *xlsxWriter {
+	return &xlsxWriter{zip: zip.NewWriter(w), created: time.Now()}
+}
+
+func (x *xlsxWriter) Header(columns []Column) error {
+	x.header = columns
+	return x.startSheet()
+}
+
+func (x *xlsxWriter) Row(values []any) error {
+	if x.sheet == nil || x.rows == maxSheetRows {
+		if err := x.startSheet(); err != nil {
+			return err
+		}
+	}
+	x.rows++
+
+	x.sheet.WriteString("<row>")
+	for _, value := range values {
+		writeCell(x.sheet, value)
+	}
+	_, err := x.sheet.WriteString("</row>")
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	if x.sheet == nil {
+		if err := x.startSheet(); err != nil {
+			return err
+		}
+	}
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+
+	var contentTypes, sheets, relationships strings.Builder
+	for i := 1; i <= x.sheets; i++ {
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+		name := "Readings"
+		if i > 1 {
+			name = fmt.Sprintf("Readings %d", i)
+		}
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, name, i, i)
+		fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			contentTypes.String() + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="` + spreadsheetNamespace + `" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets>` + sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			relationships.String() +
+			`<Relationship Id="rIdStyles" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+			`</Relationships>`},
+		{"xl/styles.xml", `<styleSheet xmlns="` + spreadsheetNamespace + `">` +
+			`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss.000"/></numFmts>` +
+			`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+			`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+			`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+			`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+			`</styleSheet>`},
+	}
+	for _, part := range parts {
+		writer, err := x.create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(writer, xml.Header+part.content); err != nil {
+			return err
+		}
+	}
+	return x.zip.Close()
+}
+
+// startSheet ends the current sheet and starts the next one with the header row, frozen
+func (x *xlsxWriter) startSheet() error {
+	if x.sheet != nil {
+		if err := x.endSheet(); err != nil {
+			return err
+		}
+	}
+	x.sheets++
+	writer, err := x.create(fmt.Sprintf("xl/worksheets/sheet%d.xml", x.sheets))
+	if err != nil {
+		return err
+	}
+	x.sheet = bufio.NewWriterSize(writer, 64*1024)
+	x.rows = 1
+
+	x.sheet.WriteString(xml.Header + `<worksheet xmlns="` + spreadsheetNamespace + `">`)
+	x.sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	x.sheet.WriteString("<sheetData><row>")
+	for _, column := range x.header {
+		writeString(x.sheet, column.Name, styleHeader)
+	}
+	_, err = x.sheet.WriteString("</row>")
+	return err
+}
+
+func (x *xlsxWriter) endSheet() error {
+	x.sheet.WriteString("</sheetData></worksheet>")
+	return x.sheet.Flush()
+}
+
+// create starts a compressed part of the zip
+func (x *xlsxWriter) create(name string) (io.Writer, error) {
+	return x.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: x.created})
+}
+
+func writeCell(w *bufio.Writer, value any) {
+	switch v := value.(type) {
+	case string:
+		writeString(w, v, 0)
+	case time.Time:
+		fmt.Fprintf(w, `<c s="%d"><v>%s</v></c>`, styleDateTime, strconv.FormatFloat(serial(v), 'f', -1, 64))
+	case int64:
+		w.WriteString("<c><v>" + strconv.FormatInt(v, 10) + "</v></c>")
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			w.WriteString("<c/>")
+			return
+		}
+		w.WriteString("<c><v>" + strconv.FormatFloat(float64(v), 'g', -1, 32) + "</v></c>")
+	case bool:
+		if v {
+			w.WriteString(`<c t="b"><v>1</v></c>`)
+		} else {
+			w.WriteString(`<c t="b"><v>0</v></c>`)
+		}
+	default:
+		w.WriteString("<c/>")
+	}
+}
+
+// writeString writes an inline string, so no shared string table has to be kept until the end
+func writeString(w *bufio.Writer, value string, style int) {
+	if style != 0 {
+		fmt.Fprintf(w, `<c t="inlineStr" s="%d">`, style)
+	} else {
+		w.WriteString(`<c t="inlineStr">`)
+	}
+	w.WriteString(`<is><t xml:space="preserve">`)
+	xml.EscapeText(w, []byte(value))
+	w.WriteString("</t></is></c>")
+}
+
+// serial is the Excel date of the wall clock time of t, which has no time zone of its own
+func serial(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return wall.Sub(excelEpoch).Hours() / 24
+}
@@ -0,0 +1,180 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How long /readyz waits for all checks together
+const checkTimeout = 3 * time.Second
+
+// Status of a dependency or background job
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check reports the state of a dependency: an error means it is down, details are shown either way
+type Check func(ctx context.Context) (any, error)
+
+// Component is one entry of the /readyz breakdown
+type Component struct {
+	Status   string `json:"Status"`
+	Critical bool   `json:"Critical"`
+	Error    string `json:"Error,omitempty"`
+	Details  any    `json:"Details,omitempty"`
+}
+
+// Job is the last known state of a background job, e.g. a Modbus poller
+type Job struct {
+	Status      string     `json:"Status"`
+	LastRun     time.Time  `json:"LastRun"`
+	LastSuccess *time.Time `json:"LastSuccess,omitempty"`
+	Failures    int        `json:"Failures"`
+	Error       string     `json:"Error,omitempty"`
+}
+
+type check struct {
+	critical bool
+	run      Check
+}
+
+var (
+	started = time.Now()
+
+	checks      = make(map[string]check)
+	checksMutex sync.RWMutex
+
+	jobs      = make(map[string]Job)
+	jobsMutex sync.Mutex
+)
+
+// Register adds a dependency to /readyz; the server is not ready while a critical one is down
+func Register(name string, critical bool, run Check) {
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+	checks[name] = check{critical: critical, run: run}
+}
+
+// JobRan records the outcome of one run of a background job; a nil error is a success
+func JobRan(name string, err error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	job := jobs[name]
+	job.LastRun = time.Now()
+	if err != nil {
+		job.Status = StatusDown
+		job.Failures++
+		job.Error = err.Error()
+	} else {
+		job.Status = StatusUp
+		lastSuccess := job.LastRun
+		job.LastSuccess = &lastSuccess
+		job.Failures = 0
+		job.Error = ""
+	}
+	jobs[name] = job
+}
+
+// * liveness: the process is running and serving requests
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"Status": StatusUp,
+		"Uptime": time.Since(started).Round(time.Second).String(),
+	})
+}
+
+// * readiness: every registered dependency and background job, 503 if a critical dependency is down
+func HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	components := runChecks(ctx)
+	status := StatusUp
+	for _, component := range components {
+		if component.Critical && component.Status == StatusDown {
+			status = StatusDown
+		}
+	}
+
+	jobsMutex.Lock()
+	jobsCopy := make(map[string]Job, len(jobs))
+	for name, job := range jobs {
+		jobsCopy[name] = job
+	}
+	jobsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if status != StatusUp {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"Status":     status,
+		"Components": components,
+		"Jobs":       jobsCopy,
+	})
+}
+
+// runChecks runs the checks in parallel; a check still running at the deadline counts as down
+func runChecks(ctx context.Context) map[string]Component {
+	checksMutex.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	registered := make([]check, len(names))
+	for i, name := range names {
+		registered[i] = checks[name]
+	}
+	checksMutex.RUnlock()
+
+	results := make([]Component, len(names))
+	var wait sync.WaitGroup
+	for i := range names {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			results[i] = runCheck(ctx, registered[i])
+		}()
+	}
+	wait.Wait()
+
+	components := make(map[string]Component, len(names))
+	for i, name := range names {
+		components[name] = results[i]
+	}
+	return components
+}
+
+func runCheck(ctx context.Context, registered check) Component {
+	type outcome struct {
+		details any
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		details, err := registered.run(ctx)
+		done <- outcome{details, err}
+	}()
+
+	component := Component{Status: StatusUp, Critical: registered.critical}
+	select {
+	case result := <-done:
+		component.Details = result.details
+		if result.err != nil {
+			component.Status = StatusDown
+			component.Error = result.err.Error()
+		}
+	case <-ctx.Done():
+		component.Status = StatusDown
+		component.Error = "check timed out"
+	}
+	return component
+}
@@ -0,0 +1,99 @@
+package ingest
+
+import (
+	"time"
+
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/events"
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// Outcome of a reading in a batch
+const (
+	StatusStored    = "stored"
+	StatusDuplicate = "duplicate"
+	StatusRejected  = "rejected"
+	StatusFailed    = "failed"
+)
+
+// Result is the outcome of one reading of a batch, Index is its position in the request
+type Result struct {
+	Index  int    `json:"Index"`
+	Status string `json:"Status"`
+	Error  string `json:"Error,omitempty"`
+}
+
+// Batch validates readings one by one and stores the valid ones in groups of Size
+type Batch struct {
+	organization string
+	source       string
+	size         int
+	pending      []schema.GyroData
+	indexes      []int
+	Results      []Result
+}
+
+// NewBatch creates a batch for readings arriving on source, see Decode for organization
+func NewBatch(organization string, source string, size int) *Batch {
+	if size <= 0 {
+		size = 1
+	}
+	return &Batch{organization: organization, source: source, size: size}
+}
+
+// Add validates a JSON reading and queues it for storage, storing the queue once it is full
+func (b *Batch) Add(raw []byte) {
+	index := len(b.Results)
+	b.Results = append(b.Results, Result{Index: index})
+
+	data, err := Payload(b.organization, b.source, raw)
+	if err != nil {
+		b.Results[index].Status = StatusRejected
+		b.Results[index].Error = err.Error()
+		return
+	}
+
+	b.pending = append(b.pending, data)
+	b.indexes = append(b.indexes, index)
+	queued.Add(1)
+	if len(b.pending) >= b.size {
+		b.Flush()
+	}
+}
+
+// Flush stores the queued readings
+func (b *Batch) Flush() {
+	stored, errs := db.StoreGyroDataBatch(b.pending)
+	now := time.Now()
+	for i, index := range b.indexes {
+		switch {
+		case errs[i] != nil:
+			b.Results[index].Status = StatusFailed
+			b.Results[index].Error = errs[i].Error()
+			metrics.Stored(metrics.ResultFailed, 1)
+		case stored[i]:
+			b.Results[index].Status = StatusStored
+			metrics.Stored(metrics.ResultStored, 1)
+			lastReading(b.pending[i].DeviceAddress, now)
+			events.Reading(b.pending[i])
+		default:
+			b.Results[index].Status = StatusDuplicate
+			metrics.Stored(metrics.ResultDuplicate, 1)
+		}
+	}
+	queued.Add(-int64(len(b.pending)))
+	b.pending = b.pending[:0]
+	b.indexes = b.indexes[:0]
+}
+
+// Count returns how many readings ended with the given status
+func (b *Batch) Count(status string) int {
+	count := 0
+	for _, result := range b.Results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,517 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+	"GOLANG_SERVER/components/validate"
+)
+
+// Formats of an import file
+const (
+	ImportCSV    = "csv"
+	ImportNDJSON = "ndjson"
+)
+
+// Most errors an import report lists, the others are only counted
+const maxImportErrors = 100
+
+// How many readings an import reads between two progress reports
+const importProgressRows = 10000
+
+// ImportOptions describes an import file and where its readings go
+type ImportOptions struct {
+	Format string
+	// Mapping maps CSV columns to reading fields, e.g. "ts" to "TimeStamp" and "ax" to "X.Acceleration".
+	// Columns named like a field, as /export writes them, need no mapping; other columns are ignored.
+	Mapping map[string]string
+	// Delimiter separates CSV columns, a comma if 0
+	Delimiter rune
+	// Device is the device of CSV rows without a DeviceAddress, e.g. a file from one gateway's SD card
+	Device string
+	// Location is the time zone of DateTime values without an offset, UTC if nil
+	Location *time.Location
+	// Organization the devices must be registered to, see Decode
+	Organization string
+	BatchSize    int
+	// Progress is called with the report so far while the import runs, if set
+	Progress func(ImportReport)
+}
+
+// ImportReport summarises an import; Errors lists the first rejected or failed lines
+type ImportReport struct {
+	Received       int           `json:"Received"`
+	Stored         int           `json:"Stored"`
+	Duplicates     int           `json:"Duplicates"`
+	Rejected       int           `json:"Rejected"`
+	Failed         int           `json:"Failed"`
+	IgnoredColumns []string      `json:"IgnoredColumns,omitempty"`
+	Errors         []ImportError `json:"Errors"`
+	// Done is set once the whole file is read, Error if it could not be
+	Done  bool   `json:"Done"`
+	Error string `json:"Error,omitempty"`
+}
+
+// ImportError is why the reading on a line of the file was not stored
+type ImportError struct {
+	Line  int    `json:"Line"`
+	Error string `json:"Error"`
+}
+
+// Import reads historical readings from a CSV or NDJSON file, validates them and stores them in batches.
+// Unlike live readings they keep their own time, taken from TimeStamp (Unix ms) or else DateTime.
+// Readings that fail are reported rather than quarantined. The error is about the file itself, e.g.
+// malformed CSV; the report then covers the readings before it.
+func Import(ctx context.Context, reader io.Reader, options ImportOptions) (ImportReport, error) {
+	if options.Location == nil {
+		options.Location = time.UTC
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 1
+	}
+	importer := &importer{options: options, report: ImportReport{Errors: []ImportError{}}}
+
+	var err error
+	switch options.Format {
+	case ImportCSV:
+		err = importer.readCSV(ctx, reader)
+	case ImportNDJSON:
+		err = importer.readLines(ctx, reader)
+	default:
+		err = fmt.Errorf("unknown format %q, use csv or ndjson", options.Format)
+	}
+	importer.flush()
+	importer.report.Done = true
+	if err != nil {
+		importer.report.Error = err.Error()
+	}
+	return importer.report, err
+}
+
+// ImportFormatOf returns the format of a file from its name, "" if the extension is not known.
+// Names may end in .gz, see Compressed.
+func ImportFormatOf(name string) string {
+	switch path.Ext(strings.TrimSuffix(strings.ToLower(name), ".gz")) {
+	case ".csv", ".txt":
+		return ImportCSV
+	case ".ndjson", ".jsonl", ".json":
+		return ImportNDJSON
+	}
+	return ""
+}
+
+// Compressed reports whether a file name is the one of a gzip-compressed file
+func Compressed(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".gz")
+}
+
+// ParseMapping parses a CSV column mapping written as "column=Field,column=Field"
+func ParseMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		column, field, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mapping %q, use column=Field", pair)
+		}
+		mapping[strings.TrimSpace(column)] = strings.TrimSpace(field)
+	}
+	return mapping, nil
+}
+
+type importer struct {
+	options ImportOptions
+	report  ImportReport
+	pending []schema.GyroData
+	lines   []int
+}
+
+// readLines imports one JSON reading per line, validated like the ones sent to /store
+func (i *importer) readLines(ctx context.Context, reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		data, err := validate.Payload(raw)
+		i.add(line, data, err)
+	}
+	return scanner.Err()
+}
+
+// readCSV imports one reading per row, the first row names the columns
+func (i *importer) readCSV(ctx context.Context, reader io.Reader) error {
+	rows := csv.NewReader(reader)
+	if i.options.Delimiter != 0 {
+		rows.Comma = i.options.Delimiter
+	}
+	rows.TrimLeadingSpace = true
+	rows.ReuseRecord = true
+
+	header, err := rows.Read()
+	if err != nil {
+		return fmt.Errorf("reading the header: %w", err)
+	}
+	columns, err := i.mapColumns(header)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := rows.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line, _ := rows.FieldPos(0)
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return err
+		}
+		if err == nil {
+			var data schema.GyroData
+			data, err = i.row(columns, record)
+			if err == nil {
+				err = validate.Reading(data)
+			}
+			i.add(line, data, err)
+			continue
+		}
+		// * a row with a missing or extra cell is rejected on its own
+		i.add(line, schema.GyroData{}, err)
+	}
+}
+
+// mapColumns returns the field of every column of the header, nil for ignored ones
+func (i *importer) mapColumns(header []string) ([]*importField, error) {
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	mapping := make(map[string]string, len(i.options.Mapping))
+	for column, field := range i.options.Mapping {
+		mapping[strings.ToLower(column)] = field
+	}
+
+	columns := make([]*importField, len(header))
+	mapped := make(map[string]bool)
+	for index, column := range header {
+		name, ok := mapping[strings.ToLower(column)]
+		if !ok {
+			name = column
+		}
+		field, known := importFieldNamed(name)
+		switch {
+		case ok && !known:
+			return nil, fmt.Errorf("column %q is mapped to unknown field %q", column, name)
+		case !known:
+			i.report.IgnoredColumns = append(i.report.IgnoredColumns, column)
+			continue
+		case mapped[field.name]:
+			return nil, fmt.Errorf("more than one column holds %s", field.name)
+		}
+		columns[index] = field
+		mapped[field.name] = true
+	}
+
+	for column := range i.options.Mapping {
+		if !containsFold(header, column) {
+			return nil, fmt.Errorf("mapped column %q is not in the file", column)
+		}
+	}
+	if !mapped["TimeStamp"] && !mapped["DateTime"] {
+		return nil, errors.New("the file has no TimeStamp or DateTime column, map the column holding the time")
+	}
+	if !mapped["DeviceAddress"] && i.options.Device == "" {
+		return nil, errors.New("the file has no DeviceAddress column, map it or give the device of the file")
+	}
+	for _, required := range validate.CurrentRules().Required {
+		if !mapped[required] && required != "DeviceAddress" {
+			return nil, fmt.Errorf("the file has no %s column, which the validation rules require", required)
+		}
+	}
+	return columns, nil
+}
+
+// row decodes a CSV record; empty cells leave their field unset
+func (i *importer) row(columns []*importField, record []string) (schema.GyroData, error) {
+	data := schema.GyroData{DeviceAddress: i.options.Device}
+	var problems []string
+	for index, field := range columns {
+		if field == nil {
+			continue
+		}
+		value := strings.TrimSpace(record[index])
+		if value == "" {
+			// * an empty DeviceAddress is the file's device, validate.Reading checks it is set
+			if field.required() && field.name != "DeviceAddress" {
+				problems = append(problems, field.name+" is required")
+			}
+			continue
+		}
+		if err := field.set(&data, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", field.name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return schema.GyroData{}, errors.New(strings.Join(problems, "; "))
+	}
+	return data, nil
+}
+
+// add queues a decoded reading, or counts it as rejected with the error of decoding it
+func (i *importer) add(line int, data schema.GyroData, err error) {
+	i.report.Received++
+	metrics.Received(SourceImport)
+	if err == nil {
+		err = i.prepare(&data)
+	}
+	if err != nil {
+		metrics.Rejected(SourceImport)
+		i.report.Rejected++
+		i.problem(line, err)
+	} else {
+		i.pending = append(i.pending, data)
+		i.lines = append(i.lines, line)
+		queued.Add(1)
+		if len(i.pending) >= i.options.BatchSize {
+			i.flush()
+		}
+	}
+
+	if i.options.Progress != nil && i.report.Received%importProgressRows == 0 {
+		i.options.Progress(i.report)
+	}
+}
+
+// prepare sets the time and organization of a validated reading
+func (i *importer) prepare(data *schema.GyroData) error {
+	var at time.Time
+	switch {
+	case data.TimeStamp > 0:
+		at = time.UnixMilli(data.TimeStamp)
+	case data.DateTime != "":
+		parsed, err := parseDateTime(data.DateTime, i.options.Location)
+		if err != nil {
+			return err
+		}
+		at = parsed
+	default:
+		return errors.New("TimeStamp or DateTime is required")
+	}
+	// * a minute of leeway for gateway clocks running ahead
+	if at.After(time.Now().Add(time.Minute)) {
+		return fmt.Errorf("time %s is in the future", at.UTC().Format(time.RFC3339))
+	}
+	dateTime, err := db.DateTimeOf(at)
+	if err != nil {
+		return err
+	}
+	data.DateTime, data.TimeStamp = dateTime, at.UnixMilli()
+
+	// * readings without an identifier are recognised by their content, importing a file twice stores them once
+	if data.MessageID == "" && data.Sequence == nil && data.DeviceTimeStamp == 0 {
+		hash, err := importHash(*data)
+		if err != nil {
+			return err
+		}
+		data.ImportHash = hash
+	}
+	return assign(data, i.options.Organization)
+}
+
+// importHash is the SHA-256 of a reading's JSON, which has every field sent by a gateway
+func importHash(data schema.GyroData) (string, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// flush stores the queued readings. They are not published as events, subscribers only get live readings.
+func (i *importer) flush() {
+	if len(i.pending) == 0 {
+		return
+	}
+	stored, errs := db.ImportGyroDataBatch(i.pending)
+	now := time.Now()
+	for index, line := range i.lines {
+		switch {
+		case errs[index] != nil:
+			i.report.Failed++
+			i.problem(line, errs[index])
+			metrics.Stored(metrics.ResultFailed, 1)
+		case stored[index]:
+			i.report.Stored++
+			metrics.Stored(metrics.ResultStored, 1)
+			lastReading(i.pending[index].DeviceAddress, now)
+		default:
+			i.report.Duplicates++
+			metrics.Stored(metrics.ResultDuplicate, 1)
+		}
+	}
+	queued.Add(-int64(len(i.pending)))
+	i.pending = i.pending[:0]
+	i.lines = i.lines[:0]
+}
+
+func (i *importer) problem(line int, err error) {
+	if len(i.report.Errors) < maxImportErrors {
+		i.report.Errors = append(i.report.Errors, ImportError{Line: line, Error: err.Error()})
+	}
+}
+
+// Layouts of DateTime values besides Unix ms in TimeStamp, the first two with an offset
+var dateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006/01/02 15:04:05.999999999",
+}
+
+// parseDateTime parses a date and time, in location if it has no offset
+func parseDateTime(value string, location *time.Location) (time.Time, error) {
+	for _, layout := range dateTimeLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, location); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid DateTime %q, use RFC 3339 or 2006-01-02 15:04:05", value)
+}
+
+// importField is a field of a reading a CSV column can hold
+type importField struct {
+	name string
+	set  func(data *schema.GyroData, value string) error
+}
+
+func (f *importField) required() bool {
+	for _, field := range validate.CurrentRules().Required {
+		if field == f.name {
+			return true
+		}
+	}
+	return false
+}
+
+var importFields = newImportFields()
+
+func importFieldNamed(name string) (*importField, bool) {
+	field, ok := importFields[strings.ToLower(strings.TrimSpace(name))]
+	return field, ok
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func newImportFields() map[string]*importField {
+	list := []*importField{
+		{"DeviceAddress", func(data *schema.GyroData, value string) error { data.DeviceAddress = value; return nil }},
+		{"DateTime", func(data *schema.GyroData, value string) error { data.DateTime = value; return nil }},
+		{"TimeStamp", integer(func(data *schema.GyroData) *int64 { return &data.TimeStamp })},
+		{"Temperature", float(func(data *schema.GyroData) *float32 { return &data.Temperature })},
+		{"ModbusHighSpeed", func(data *schema.GyroData, value string) error {
+			var err error
+			data.ModbusHighSpeed, err = strconv.ParseBool(value)
+			return err
+		}},
+		{"MessageID", func(data *schema.GyroData, value string) error { data.MessageID = value; return nil }},
+		{"BootID", func(data *schema.GyroData, value string) error { data.BootID = value; return nil }},
+		{"Sequence", integer(func(data *schema.GyroData) *int64 {
+			data.Sequence = new(int64)
+			return data.Sequence
+		})},
+		{"DeviceTimeStamp", integer(func(data *schema.GyroData) *int64 { return &data.DeviceTimeStamp })},
+	}
+
+	axes := []struct {
+		name string
+		axis func(*schema.GyroData) *schema.GyroStruct
+	}{
+		{"X", func(data *schema.GyroData) *schema.GyroStruct { return &data.X }},
+		{"Y", func(data *schema.GyroData) *schema.GyroStruct { return &data.Y }},
+		{"Z", func(data *schema.GyroData) *schema.GyroStruct { return &data.Z }},
+	}
+	fields := []struct {
+		name  string
+		field func(*schema.GyroStruct) *float32
+	}{
+		{"Acceleration", func(axis *schema.GyroStruct) *float32 { return &axis.Acceleration }},
+		{"VelocityAngular", func(axis *schema.GyroStruct) *float32 { return &axis.VelocityAngular }},
+		{"VibrationSpeed", func(axis *schema.GyroStruct) *float32 { return &axis.VibrationSpeed }},
+		{"VibrationAngle", func(axis *schema.GyroStruct) *float32 { return &axis.VibrationAngle }},
+		{"VibrationDisplacement", func(axis *schema.GyroStruct) *float32 { return &axis.VibrationDisplacement }},
+		{"VibrationDisplacementHighSpeed", func(axis *schema.GyroStruct) *float32 { return &axis.VibrationDisplacementHighSpeed }},
+		{"Frequency", func(axis *schema.GyroStruct) *float32 { return &axis.Frequency }},
+	}
+	for _, axis := range axes {
+		for _, field := range fields {
+			axis, field := axis, field
+			list = append(list, &importField{axis.name + "." + field.name, float(func(data *schema.GyroData) *float32 {
+				return field.field(axis.axis(data))
+			})})
+		}
+	}
+
+	named := make(map[string]*importField, len(list))
+	for _, field := range list {
+		named[strings.ToLower(field.name)] = field
+	}
+	return named
+}
+
+func integer(field func(*schema.GyroData) *int64) func(*schema.GyroData, string) error {
+	return func(data *schema.GyroData, value string) error {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		*field(data) = parsed
+		return nil
+	}
+}
+
+func float(field func(*schema.GyroData) *float32) func(*schema.GyroData, string) error {
+	return func(data *schema.GyroData, value string) error {
+		parsed, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return errors.New("not a number")
+		}
+		*field(data) = float32(parsed)
+		return nil
+	}
+}
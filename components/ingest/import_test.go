@@ -0,0 +1,44 @@
+package ingest
+
+import (
+	"testing"
+
+	schema "GOLANG_SERVER/components/schema"
+)
+
+func TestPrepareImportHash(t *testing.T) {
+	registered(t, map[string]string{"legacy-1": schema.DefaultOrganization})
+	i := &importer{}
+	prepared := func(data schema.GyroData) schema.GyroData {
+		t.Helper()
+		if err := i.prepare(&data); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	reading := schema.GyroData{DeviceAddress: "legacy-1", TimeStamp: 1706695200000, X: schema.GyroStruct{Acceleration: 1.5}}
+	first := prepared(reading)
+	if first.ImportHash == "" || first.DeviceTimeStamp != 0 {
+		t.Fatalf("reading without identifier: ImportHash %q, DeviceTimeStamp %d, want a hash and no device time", first.ImportHash, first.DeviceTimeStamp)
+	}
+
+	// * the same reading again, with its time as DateTime as /export writes it
+	again := prepared(schema.GyroData{DeviceAddress: "legacy-1", DateTime: "2024-01-31T17:00:00+07:00", X: schema.GyroStruct{Acceleration: 1.5}})
+	if again.ImportHash != first.ImportHash {
+		t.Errorf("the same reading imported twice has hashes %q and %q", first.ImportHash, again.ImportHash)
+	}
+
+	// * another reading of the same device and time is not a duplicate
+	other := reading
+	other.X.Acceleration = 2
+	if prepared(other).ImportHash == first.ImportHash {
+		t.Error("readings with other values have the same hash")
+	}
+
+	withIdentifier := reading
+	withIdentifier.DeviceTimeStamp = 1706695199960
+	if data := prepared(withIdentifier); data.ImportHash != "" || data.DeviceTimeStamp != 1706695199960 {
+		t.Errorf("reading with DeviceTimeStamp: ImportHash %q, DeviceTimeStamp %d, want it left alone", data.ImportHash, data.DeviceTimeStamp)
+	}
+}
@@ -0,0 +1,192 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"GOLANG_SERVER/components/codec"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/events"
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+	"GOLANG_SERVER/components/validate"
+)
+
+// Channels a reading can arrive on, recorded with quarantined payloads
+const (
+	SourceMQTT = "mqtt"
+	SourceREST = "rest"
+	SourceWS   = "ws"
+
+	SourceModbus = "modbus"
+	SourceImport = "import"
+)
+
+var logger = logging.For("ingest")
+
+// ErrRejected is returned when a payload fails validation and was quarantined
+var ErrRejected = errors.New("payload rejected")
+
+// Decode decodes and validates a reading in the given codec format, quarantining it if it is malformed.
+// organization is the tenant the channel belongs to, "" for channels not tied to one.
+func Decode(organization string, source string, format string, raw []byte) (schema.GyroData, error) {
+	metrics.Received(source)
+	data, err := decode(organization, format, raw)
+	if err != nil {
+		return schema.GyroData{}, quarantine(organization, source, format, raw, err)
+	}
+	return data, nil
+}
+
+// Payload validates a JSON reading and quarantines it if it is malformed
+func Payload(organization string, source string, raw []byte) (schema.GyroData, error) {
+	return Decode(organization, source, codec.FormatJSON, raw)
+}
+
+// * readings accepted but not stored yet: being written, or waiting in a bulk batch
+var queued atomic.Int64
+
+// QueueDepth returns how many accepted readings are waiting to be stored
+func QueueDepth() int64 {
+	return queued.Load()
+}
+
+// HealthCheck reports the ingestion queue for /readyz
+func HealthCheck(ctx context.Context) (any, error) {
+	return map[string]int64{"QueueDepth": QueueDepth()}, nil
+}
+
+// Store validates a reading in the given codec format and stores it, quarantining it if it is malformed.
+// It returns false with a nil error when the reading is a duplicate of one already stored.
+func Store(organization string, source string, format string, raw []byte) (bool, error) {
+	data, err := Decode(organization, source, format, raw)
+	if err != nil {
+		return false, err
+	}
+	return store(data)
+}
+
+// Reading validates a reading produced by the server itself (e.g. the Modbus poller) and stores it.
+// A rejected reading is quarantined as JSON.
+func Reading(organization string, source string, data schema.GyroData) (bool, error) {
+	metrics.Received(source)
+	err := validate.Reading(data)
+	if err == nil {
+		err = assign(&data, organization)
+	}
+	if err != nil {
+		raw, marshalErr := json.Marshal(data)
+		if marshalErr != nil {
+			// NaN and Inf can't be written as JSON, keep a readable dump instead
+			raw = []byte(fmt.Sprintf("%+v", data))
+		}
+		return false, quarantine(organization, source, codec.FormatJSON, raw, err)
+	}
+	return store(data)
+}
+
+// Replay runs a quarantined payload through validation again and stores it if it passes now,
+// e.g. after the rules were relaxed. The record is removed once the reading is stored.
+func Replay(record schema.QuarantineRecord) error {
+	format := record.Format
+	if format == "" {
+		format = codec.FormatJSON
+	}
+	data, err := decode(record.Organization, format, record.Raw)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	if _, err := store(data); err != nil {
+		return err
+	}
+	if _, err := db.DeleteQuarantine(record.ID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// store writes one reading, counting it in the queue while it is written
+func store(data schema.GyroData) (bool, error) {
+	queued.Add(1)
+	defer queued.Add(-1)
+	stored, err := db.StoreGyroData(&data)
+	switch {
+	case err != nil:
+		metrics.Stored(metrics.ResultFailed, 1)
+	case stored:
+		metrics.Stored(metrics.ResultStored, 1)
+		lastReading(data.DeviceAddress, time.Now())
+		events.Reading(data)
+	default:
+		metrics.Stored(metrics.ResultDuplicate, 1)
+	}
+	return stored, err
+}
+
+func decode(organization string, format string, raw []byte) (schema.GyroData, error) {
+	var data schema.GyroData
+	var err error
+	if format == codec.FormatJSON {
+		data, err = validate.Payload(raw)
+	} else {
+		data, err = codec.Decode(format, raw, !validate.CurrentRules().AllowUnknown)
+		if err == nil {
+			err = validate.Reading(data)
+		}
+	}
+	if err != nil {
+		return schema.GyroData{}, err
+	}
+
+	if err := assign(&data, organization); err != nil {
+		return schema.GyroData{}, err
+	}
+	return data, nil
+}
+
+func quarantine(organization string, source string, format string, raw []byte, reason error) error {
+	metrics.Rejected(source)
+	record := schema.QuarantineRecord{
+		Source:       source,
+		Format:       format,
+		Reason:       reason.Error(),
+		Raw:          raw,
+		Organization: organization,
+	}
+	if _, err := db.StoreQuarantine(record); err != nil {
+		logger.Error("Error storing quarantined payload", "source", source, "error", err)
+	}
+	alert(organization, source, format, raw, reason)
+	return fmt.Errorf("%w: %v", ErrRejected, reason)
+}
+
+// alert tells the subscribers of a device's organization that a reading of it was rejected.
+// Payloads too malformed to name their device are only quarantined.
+func alert(organization string, source string, format string, raw []byte, reason error) {
+	var named struct{ DeviceAddress string }
+	if format == codec.FormatJSON {
+		json.Unmarshal(raw, &named)
+	} else if data, err := codec.Decode(format, raw, false); err == nil {
+		named.DeviceAddress = data.DeviceAddress
+	}
+	if named.DeviceAddress == "" {
+		return
+	}
+
+	if organization == "" {
+		deviceOrganization, err := cached(deviceOrganizations, named.DeviceAddress, db.GetDeviceOrganization)
+		if err != nil {
+			return
+		}
+		organization = deviceOrganization
+		if organization == "" {
+			organization = schema.DefaultOrganization
+		}
+	}
+	events.Rejected(organization, named.DeviceAddress, source, reason.Error())
+}
@@ -0,0 +1,103 @@
+package ingest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/metrics"
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// How long device and MQTT prefix lookups are cached, so a moved device is picked up within a minute
+const tenantCacheTTL = time.Minute
+
+type cachedOrganization struct {
+	organization string
+	expires      time.Time
+}
+
+var deviceOrganizations = make(map[string]cachedOrganization)
+var prefixOrganizations = make(map[string]cachedOrganization)
+var tenantMutex sync.Mutex
+
+// * when expired entries were last dropped from the caches
+var tenantSwept time.Time
+
+// assign sets the organization of a reading from the device registry. organization is the tenant the
+// channel belongs to (e.g. from the MQTT topic prefix); the device must be registered to it. Channels
+// not tied to a tenant ("", e.g. the "sample" topics, /store, /store/bulk or /storews) are open to anyone, they
+// only take readings of the default organization's devices and of unregistered ones, which go to the default
+// organization. Devices of other tenants send on their tenant's MQTT topic prefix.
+func assign(data *schema.GyroData, organization string) error {
+	deviceOrganization, err := cached(deviceOrganizations, data.DeviceAddress, db.GetDeviceOrganization)
+	if err != nil {
+		return err
+	}
+	if deviceOrganization == "" {
+		deviceOrganization = schema.DefaultOrganization
+	}
+
+	if organization == "" {
+		if deviceOrganization != schema.DefaultOrganization {
+			return fmt.Errorf("device %s belongs to an organization, send its readings on the organization's MQTT topic prefix", data.DeviceAddress)
+		}
+		organization = schema.DefaultOrganization
+	}
+	if deviceOrganization != organization {
+		return fmt.Errorf("device %s is not registered to organization %s", data.DeviceAddress, organization)
+	}
+	data.Organization = deviceOrganization
+	return nil
+}
+
+// PrefixOrganization returns the organization publishing on an MQTT topic prefix
+func PrefixOrganization(prefix string) (string, error) {
+	return cached(prefixOrganizations, prefix, func(prefix string) (string, error) {
+		organization, err := db.GetOrganizationByPrefix(prefix)
+		if err != nil {
+			return "", err
+		}
+		return organization.Slug, nil
+	})
+}
+
+func cached(cache map[string]cachedOrganization, key string, lookup func(string) (string, error)) (string, error) {
+	tenantMutex.Lock()
+	entry, ok := cache[key]
+	tenantMutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.organization, nil
+	}
+
+	organization, err := lookup(key)
+	if err != nil {
+		return "", err
+	}
+
+	tenantMutex.Lock()
+	defer tenantMutex.Unlock()
+	now := time.Now()
+	// * anyone can send readings of made-up devices, expired entries are dropped so they can't pile up
+	if now.Sub(tenantSwept) > tenantCacheTTL {
+		for _, cache := range []map[string]cachedOrganization{deviceOrganizations, prefixOrganizations} {
+			for key, entry := range cache {
+				if now.After(entry.expires) {
+					delete(cache, key)
+				}
+			}
+		}
+		tenantSwept = now
+	}
+	cache[key] = cachedOrganization{organization: organization, expires: now.Add(tenantCacheTTL)}
+	return organization, nil
+}
+
+// lastReading records when a registered device last had a reading stored. Unregistered devices get
+// no gauge, anyone can send readings of made-up ones on the open channels.
+func lastReading(deviceAddress string, at time.Time) {
+	if organization, err := cached(deviceOrganizations, deviceAddress, db.GetDeviceOrganization); err == nil && organization != "" {
+		metrics.LastReading(deviceAddress, at)
+	}
+}
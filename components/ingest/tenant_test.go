@@ -0,0 +1,60 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// registered fills the device cache, so assign doesn't ask the database
+func registered(t *testing.T, devices map[string]string) {
+	t.Helper()
+	tenantMutex.Lock()
+	defer tenantMutex.Unlock()
+	for device, organization := range devices {
+		deviceOrganizations[device] = cachedOrganization{organization: organization, expires: time.Now().Add(time.Hour)}
+	}
+	t.Cleanup(func() {
+		tenantMutex.Lock()
+		defer tenantMutex.Unlock()
+		for device := range devices {
+			delete(deviceOrganizations, device)
+		}
+	})
+}
+
+func TestAssign(t *testing.T) {
+	registered(t, map[string]string{
+		"acme-1":   "acme",
+		"legacy-1": schema.DefaultOrganization,
+		"new-1":    "",
+	})
+
+	tests := []struct {
+		name         string
+		device       string
+		channel      string
+		organization string
+	}{
+		{"tenant's device on an untenanted channel", "acme-1", "", ""},
+		{"tenant's device on its tenant's prefix", "acme-1", "acme", "acme"},
+		{"tenant's device on another tenant's prefix", "acme-1", "globex", ""},
+		{"default device on an untenanted channel", "legacy-1", "", schema.DefaultOrganization},
+		{"default device on a tenant's prefix", "legacy-1", "acme", ""},
+		{"unregistered device on an untenanted channel", "new-1", "", schema.DefaultOrganization},
+		{"unregistered device on a tenant's prefix", "new-1", "acme", ""},
+	}
+	for _, test := range tests {
+		data := schema.GyroData{DeviceAddress: test.device}
+		err := assign(&data, test.channel)
+		switch {
+		case test.organization == "" && err == nil:
+			t.Errorf("%s: accepted into %q, want it refused", test.name, data.Organization)
+		case test.organization != "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.organization != "" && data.Organization != test.organization:
+			t.Errorf("%s: organization = %q, want %q", test.name, data.Organization, test.organization)
+		}
+	}
+}
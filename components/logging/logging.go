@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+
+	"GOLANG_SERVER/components/config"
+)
+
+// Setup makes the configured handler the default for slog and the log package. Loggers
+// returned by For before Setup was called use it as well.
+func Setup(logConfig config.Log) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logConfig.Level)); err != nil {
+		return err
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if logConfig.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(requestHandler{handler}))
+	return nil
+}
+
+// For returns the logger of a component, every line it writes carries component=name
+func For(name string) *slog.Logger {
+	return slog.New(componentHandler{with: func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs([]slog.Attr{slog.String("component", name)})
+	}})
+}
+
+// componentHandler resolves the default handler on every line, so package level loggers
+// created at init follow Setup
+type componentHandler struct {
+	with func(slog.Handler) slog.Handler
+}
+
+func (h componentHandler) handler() slog.Handler {
+	return h.with(slog.Default().Handler())
+}
+
+func (h componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (h componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler().Handle(ctx, record)
+}
+
+func (h componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return componentHandler{with: func(handler slog.Handler) slog.Handler {
+		return h.with(handler).WithAttrs(attrs)
+	}}
+}
+
+func (h componentHandler) WithGroup(name string) slog.Handler {
+	return componentHandler{with: func(handler slog.Handler) slog.Handler {
+		return h.with(handler).WithGroup(name)
+	}}
+}
+
+// requestHandler adds the request ID of the context to every line logged with one
+type requestHandler struct {
+	slog.Handler
+}
+
+func (h requestHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestHandler) WithGroup(name string) slog.Handler {
+	return requestHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of the context, "" if it has none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcome of storing a reading
+const (
+	ResultStored    = "stored"
+	ResultDuplicate = "duplicate"
+	ResultFailed    = "failed"
+)
+
+// * every metric is registered on the default registry, /metrics serves them with the Go runtime ones
+var (
+	messagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gyro_messages_received_total",
+		Help: "Readings received, by channel.",
+	}, []string{"source"})
+
+	validationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gyro_validation_failures_total",
+		Help: "Readings rejected by validation and quarantined, by channel.",
+	}, []string{"source"})
+
+	readingsStored = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gyro_readings_stored_total",
+		Help: "Readings written to MongoDB, by result (stored, duplicate or failed).",
+	}, []string{"result"})
+
+	insertDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gyro_db_insert_duration_seconds",
+		Help:    "Time taken by MongoDB inserts of readings, by operation (one or batch).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gyro_db_query_duration_seconds",
+		Help:    "Time taken by MongoDB queries, by db function.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"function"})
+
+	webSocketClients = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gyro_websocket_clients",
+		Help: "Connected WebSocket clients, by endpoint.",
+	}, []string{"endpoint"})
+
+	droppedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gyro_websocket_dropped_messages_total",
+		Help: "Messages that could not be delivered to a WebSocket client, by endpoint.",
+	}, []string{"endpoint"})
+
+	lastReading = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gyro_device_last_reading_timestamp_seconds",
+		Help: "Unix time of the last reading stored for a registered device.",
+	}, []string{"device"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// QueueDepth exposes the number of readings waiting to be stored
+func QueueDepth(depth func() int64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gyro_ingest_queue_depth",
+		Help: "Accepted readings waiting to be stored.",
+	}, func() float64 { return float64(depth()) })
+}
+
+// Received counts a reading arriving on a channel
+func Received(source string) {
+	messagesReceived.WithLabelValues(source).Inc()
+}
+
+// Rejected counts a reading that failed validation
+func Rejected(source string) {
+	validationFailures.WithLabelValues(source).Inc()
+}
+
+// Stored counts readings written with the given result
+func Stored(result string, count int) {
+	readingsStored.WithLabelValues(result).Add(float64(count))
+}
+
+// LastReading records when a device last had a reading stored
+func LastReading(device string, at time.Time) {
+	lastReading.WithLabelValues(device).Set(float64(at.UnixMilli()) / 1000)
+}
+
+// Insert starts timing an insert, call the returned func once it is done
+func Insert(operation string) func() {
+	return timer(insertDuration.WithLabelValues(operation))
+}
+
+// Query starts timing a db function, call the returned func once it is done
+func Query(function string) func() {
+	return timer(queryDuration.WithLabelValues(function))
+}
+
+// ClientConnected counts a WebSocket client in until the returned func is called
+func ClientConnected(endpoint string) func() {
+	clients := webSocketClients.WithLabelValues(endpoint)
+	clients.Inc()
+	return clients.Dec
+}
+
+// Dropped counts a message a WebSocket client did not get
+func Dropped(endpoint string) {
+	droppedMessages.WithLabelValues(endpoint).Inc()
+}
+
+func timer(observer prometheus.Observer) func() {
+	start := time.Now()
+	return func() {
+		observer.Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,242 @@
+package modbus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"GOLANG_SERVER/components/health"
+	"GOLANG_SERVER/components/ingest"
+	"GOLANG_SERVER/components/logging"
+	schema "GOLANG_SERVER/components/schema"
+)
+
+var logger = logging.For("modbus")
+
+// Register maps one holding register to a reading field: value = raw * Scale (raw read as int16 if Signed)
+type Register struct {
+	Address uint16  `json:"Address"`
+	Scale   float64 `json:"Scale"`
+	Signed  bool    `json:"Signed"`
+}
+
+// Device is a sensor polled by the server. Transport is "tcp" (Address is host:port)
+// or "rtu" (Address is a serial device path such as /dev/ttyUSB0). Organization is the one the
+// device is registered to, the default organization if empty.
+type Device struct {
+	DeviceAddress   string              `json:"DeviceAddress"`
+	Organization    string              `json:"Organization"`
+	Transport       string              `json:"Transport"`
+	Address         string              `json:"Address"`
+	UnitID          byte                `json:"UnitID"`
+	Interval        string              `json:"Interval"`
+	Timeout         string              `json:"Timeout"`
+	ModbusHighSpeed bool                `json:"ModbusHighSpeed"`
+	Registers       map[string]Register `json:"Registers"`
+}
+
+// Config is the content of the file named by MODBUS_CONFIG
+type Config struct {
+	Devices []Device `json:"Devices"`
+}
+
+// WitMotionRegisters is the register map of WitMotion WTVB01-485 style vibration sensors,
+// used for devices that don't configure their own
+func WitMotionRegisters() map[string]Register {
+	return map[string]Register{
+		"X.Acceleration":          {Address: 0x34, Scale: 16.0 * 9.8 / 32768, Signed: true},
+		"Y.Acceleration":          {Address: 0x35, Scale: 16.0 * 9.8 / 32768, Signed: true},
+		"Z.Acceleration":          {Address: 0x36, Scale: 16.0 * 9.8 / 32768, Signed: true},
+		"X.VelocityAngular":       {Address: 0x37, Scale: 2000.0 / 32768, Signed: true},
+		"Y.VelocityAngular":       {Address: 0x38, Scale: 2000.0 / 32768, Signed: true},
+		"Z.VelocityAngular":       {Address: 0x39, Scale: 2000.0 / 32768, Signed: true},
+		"X.VibrationSpeed":        {Address: 0x3A, Scale: 1},
+		"Y.VibrationSpeed":        {Address: 0x3B, Scale: 1},
+		"Z.VibrationSpeed":        {Address: 0x3C, Scale: 1},
+		"X.VibrationAngle":        {Address: 0x3D, Scale: 180.0 / 32768, Signed: true},
+		"Y.VibrationAngle":        {Address: 0x3E, Scale: 180.0 / 32768, Signed: true},
+		"Z.VibrationAngle":        {Address: 0x3F, Scale: 180.0 / 32768, Signed: true},
+		"Temperature":             {Address: 0x40, Scale: 0.01, Signed: true},
+		"X.VibrationDisplacement": {Address: 0x41, Scale: 1},
+		"Y.VibrationDisplacement": {Address: 0x42, Scale: 1},
+		"Z.VibrationDisplacement": {Address: 0x43, Scale: 1},
+		"X.Frequency":             {Address: 0x44, Scale: 0.1},
+		"Y.Frequency":             {Address: 0x45, Scale: 0.1},
+		"Z.Frequency":             {Address: 0x46, Scale: 0.1},
+	}
+}
+
+// LoadConfig reads the poller configuration from a JSON file (MODBUS_CONFIG).
+// It returns an empty config if path is empty.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	if path == "" {
+		return config, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("invalid modbus config in %s: %w", path, err)
+	}
+
+	for i := range config.Devices {
+		device := &config.Devices[i]
+		if device.DeviceAddress == "" || device.Address == "" {
+			return config, fmt.Errorf("invalid modbus config in %s: device %d needs DeviceAddress and Address", path, i)
+		}
+		if device.Transport != "tcp" && device.Transport != "rtu" {
+			return config, fmt.Errorf("invalid modbus config in %s: unknown transport %q", path, device.Transport)
+		}
+		if len(device.Registers) == 0 {
+			device.Registers = WitMotionRegisters()
+		}
+		for field := range device.Registers {
+			if fieldPointer(&schema.GyroData{}, field) == nil {
+				return config, fmt.Errorf("invalid modbus config in %s: unknown field %q", path, field)
+			}
+		}
+		if _, _, err := registerBlock(device.Registers); err != nil {
+			return config, fmt.Errorf("invalid modbus config in %s: %s: %w", path, device.DeviceAddress, err)
+		}
+	}
+	return config, nil
+}
+
+// HandleModbus polls every device of the configuration file in its own goroutine
+func HandleModbus(path string) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		logger.Error("Error loading modbus config", "path", path, "error", err)
+		return
+	}
+	if len(config.Devices) == 0 {
+		return
+	}
+
+	for _, device := range config.Devices {
+		go poll(device)
+	}
+	logger.Info("Modbus poller started", "devices", len(config.Devices))
+}
+
+// poll reads the device every interval and feeds the readings into the ingestion path
+func poll(device Device) {
+	interval := parseDuration(device.Interval, time.Second)
+	timeout := parseDuration(device.Timeout, interval)
+
+	var conn transport
+	if device.Transport == "rtu" {
+		conn = newRTUTransport(device.Address, timeout)
+	} else {
+		conn = newTCPTransport(device.Address, timeout)
+	}
+	defer conn.Close()
+
+	start, count, _ := registerBlock(device.Registers)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		registers, err := conn.ReadHoldingRegisters(device.UnitID, start, count)
+		health.JobRan("modbus "+device.DeviceAddress, err)
+		if err != nil {
+			logger.Warn("Error polling modbus device", "device", device.DeviceAddress, "error", err)
+			continue
+		}
+
+		data := decodeRegisters(device, start, registers)
+		if _, err := ingest.Reading(device.Organization, ingest.SourceModbus, data); err != nil {
+			logger.Warn("Error storing modbus reading", "device", device.DeviceAddress, "error", err)
+		}
+	}
+}
+
+// registerBlock returns the smallest contiguous block covering every mapped register
+func registerBlock(registers map[string]Register) (uint16, uint16, error) {
+	if len(registers) == 0 {
+		return 0, 0, errors.New("no registers mapped")
+	}
+	first, last := uint16(0xFFFF), uint16(0)
+	for _, register := range registers {
+		first = min(first, register.Address)
+		last = max(last, register.Address)
+	}
+	count := last - first + 1
+	if count > maxRegisters {
+		return 0, 0, fmt.Errorf("registers span %d addresses, at most %d can be read at once", count, maxRegisters)
+	}
+	return first, count, nil
+}
+
+// decodeRegisters maps a register block read from start onto a reading
+func decodeRegisters(device Device, start uint16, registers []uint16) schema.GyroData {
+	data := schema.GyroData{
+		DeviceAddress:   device.DeviceAddress,
+		ModbusHighSpeed: device.ModbusHighSpeed,
+	}
+	for field, register := range device.Registers {
+		raw := registers[register.Address-start]
+		value := float64(raw)
+		if register.Signed {
+			value = float64(int16(raw))
+		}
+		*fieldPointer(&data, field) = float32(value * register.Scale)
+	}
+	return data
+}
+
+// fieldPointer returns the reading field named by a path such as "Temperature" or "X.VibrationSpeed"
+func fieldPointer(data *schema.GyroData, path string) *float32 {
+	if path == "Temperature" {
+		return &data.Temperature
+	}
+
+	axisName, field, ok := strings.Cut(path, ".")
+	if !ok {
+		return nil
+	}
+	var axis *schema.GyroStruct
+	switch axisName {
+	case "X":
+		axis = &data.X
+	case "Y":
+		axis = &data.Y
+	case "Z":
+		axis = &data.Z
+	default:
+		return nil
+	}
+
+	switch field {
+	case "Acceleration":
+		return &axis.Acceleration
+	case "VelocityAngular":
+		return &axis.VelocityAngular
+	case "VibrationSpeed":
+		return &axis.VibrationSpeed
+	case "VibrationAngle":
+		return &axis.VibrationAngle
+	case "VibrationDisplacement":
+		return &axis.VibrationDisplacement
+	case "VibrationDisplacementHighSpeed":
+		return &axis.VibrationDisplacementHighSpeed
+	case "Frequency":
+		return &axis.Frequency
+	default:
+		return nil
+	}
+}
+
+func parseDuration(value string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
+}
@@ -0,0 +1,271 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startSimulator serves TCP on a free local port until the test ends
+func startSimulator(t *testing.T) *Simulator {
+	t.Helper()
+	simulator := NewSimulator("127.0.0.1:0")
+	if err := simulator.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { simulator.Close() })
+	return simulator
+}
+
+// rtuTo returns an RTU transport whose line is one end of a pipe, the other end served by serve
+func rtuTo(t *testing.T, serve func(line io.ReadWriter)) *rtuTransport {
+	t.Helper()
+	transport := newRTUTransport("", time.Second)
+	transport.open = func() (io.ReadWriteCloser, error) {
+		client, slave := net.Pipe()
+		go func() {
+			defer slave.Close()
+			serve(slave)
+		}()
+		return client, nil
+	}
+	t.Cleanup(func() { transport.Close() })
+	return transport
+}
+
+func TestCRC16(t *testing.T) {
+	// * the read request of the Modbus specification's example: 01 03 00 00 00 0A C5 CD
+	crc := crc16([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A})
+	if crc != 0xCDC5 {
+		t.Fatalf("crc16 = %#04x, want 0xcdc5", crc)
+	}
+}
+
+func TestTCPReadsRegisters(t *testing.T) {
+	simulator := startSimulator(t)
+	simulator.SetRegister(0x34, 0x1234)
+	simulator.SetRegister(0x35, 0xFFFF)
+
+	transport := newTCPTransport(simulator.Addr(), time.Second)
+	defer transport.Close()
+	for poll := 0; poll < 2; poll++ {
+		registers, err := transport.ReadHoldingRegisters(1, 0x34, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if registers[0] != 0x1234 || registers[1] != 0xFFFF {
+			t.Fatalf("poll %d: registers = %#04x, want [0x1234 0xffff]", poll, registers)
+		}
+	}
+}
+
+func TestTCPExceptionReplies(t *testing.T) {
+	simulator := startSimulator(t)
+	transport := newTCPTransport(simulator.Addr(), time.Second)
+	defer transport.Close()
+
+	tests := []struct {
+		name  string
+		start uint16
+		count uint16
+		code  string
+	}{
+		{"no registers", 0, 0, "exception code 2"},
+		{"too many registers", 0, maxRegisters + 1, "exception code 2"},
+		{"past the last address", 0xFFFF, 2, "exception code 2"},
+	}
+	for _, test := range tests {
+		_, err := transport.ReadHoldingRegisters(1, test.start, test.count)
+		if err == nil || !strings.Contains(err.Error(), test.code) {
+			t.Errorf("%s: error = %v, want %s", test.name, err, test.code)
+		}
+	}
+
+	// * the connection is dropped after an error, the next poll reconnects
+	if _, err := transport.ReadHoldingRegisters(1, 0x34, 1); err != nil {
+		t.Fatalf("poll after an exception: %v", err)
+	}
+}
+
+func TestSimulatorRejectsUnknownFunction(t *testing.T) {
+	simulator := NewSimulator("")
+	response := simulator.handle([]byte{0x06, 0x00, 0x34, 0x00, 0x01})
+	if len(response) != 2 || response[0] != 0x86 || response[1] != 0x01 {
+		t.Fatalf("response = % x, want 86 01 (illegal function)", response)
+	}
+}
+
+func TestRTUReadsRegisters(t *testing.T) {
+	simulator := NewSimulator("")
+	simulator.SetRegister(0x40, uint16(2550))
+	transport := rtuTo(t, func(line io.ReadWriter) { simulator.ServeRTU(line, 7) })
+
+	registers, err := transport.ReadHoldingRegisters(7, 0x40, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registers[0] != 2550 {
+		t.Fatalf("register = %d, want 2550", registers[0])
+	}
+}
+
+func TestRTUExceptionReply(t *testing.T) {
+	simulator := NewSimulator("")
+	transport := rtuTo(t, func(line io.ReadWriter) { simulator.ServeRTU(line, 1) })
+
+	_, err := transport.ReadHoldingRegisters(1, 0, 0)
+	if err == nil || !strings.Contains(err.Error(), "exception code 2") {
+		t.Fatalf("error = %v, want exception code 2", err)
+	}
+}
+
+func TestRTURejectsBadCRC(t *testing.T) {
+	transport := rtuTo(t, func(line io.ReadWriter) {
+		request := make([]byte, 8)
+		if _, err := io.ReadFull(line, request); err != nil {
+			return
+		}
+		response := []byte{1, readHoldingRegisters, 2, 0x12, 0x34}
+		response = binary.LittleEndian.AppendUint16(response, crc16(response)^0xFFFF)
+		line.Write(response)
+	})
+
+	_, err := transport.ReadHoldingRegisters(1, 0x34, 1)
+	if err == nil || !strings.Contains(err.Error(), "crc mismatch") {
+		t.Fatalf("error = %v, want a crc mismatch", err)
+	}
+}
+
+func TestRTURejectsOtherUnit(t *testing.T) {
+	transport := rtuTo(t, func(line io.ReadWriter) {
+		request := make([]byte, 8)
+		if _, err := io.ReadFull(line, request); err != nil {
+			return
+		}
+		response := []byte{2, readHoldingRegisters, 2, 0x12, 0x34}
+		response = binary.LittleEndian.AppendUint16(response, crc16(response))
+		line.Write(response)
+	})
+
+	_, err := transport.ReadHoldingRegisters(1, 0x34, 1)
+	if err == nil || !strings.Contains(err.Error(), "from unit 2") {
+		t.Fatalf("error = %v, want a response from the wrong unit", err)
+	}
+}
+
+func TestRTUSimulatorIgnoresOtherUnits(t *testing.T) {
+	simulator := NewSimulator("")
+	transport := rtuTo(t, func(line io.ReadWriter) { simulator.ServeRTU(line, 1) })
+	transport.timeout = 100 * time.Millisecond
+
+	// * net.Pipe honours deadlines, the unanswered poll times out
+	if _, err := transport.ReadHoldingRegisters(2, 0x34, 1); err == nil {
+		t.Fatal("a slave answered for another unit")
+	}
+}
+
+// noDeadline is a line that can't time out a read
+type noDeadline struct{ io.ReadWriteCloser }
+
+func TestRTUNeedsReadTimeout(t *testing.T) {
+	transport := newRTUTransport("", time.Second)
+	transport.open = func() (io.ReadWriteCloser, error) {
+		client, slave := net.Pipe()
+		t.Cleanup(func() { slave.Close() })
+		return noDeadline{client}, nil
+	}
+	defer transport.Close()
+
+	// * the slave never answers, the poll fails instead of hanging
+	_, err := transport.ReadHoldingRegisters(1, 0x34, 1)
+	if err == nil || !strings.Contains(err.Error(), "no read timeout") {
+		t.Fatalf("error = %v, want the line refused for having no read timeout", err)
+	}
+}
+
+func TestDecodeWitMotionRegisters(t *testing.T) {
+	simulator := startSimulator(t)
+	simulator.SetRegister(0x34, uint16(0x4000))             // X acceleration, half of +16 g
+	simulator.SetRegister(0x36, uint16(0xC000))             // Z acceleration, half of -16 g
+	simulator.SetRegister(0x3A, 12)                         // X vibration speed in mm/s
+	simulator.SetRegister(0x40, uint16(0x10000-250))        // -2.5 °C
+	simulator.SetRegister(0x46, 505)                        // Z frequency in 0.1 Hz
+	simulator.SetRegister(0x43, uint16(math.MaxUint16-100)) // unsigned displacement stays positive
+
+	device := Device{DeviceAddress: "sim-1", Registers: WitMotionRegisters(), ModbusHighSpeed: true}
+	start, count, err := registerBlock(device.Registers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 0x34 || count != 0x46-0x34+1 {
+		t.Fatalf("block = %#x+%d, want 0x34+19", start, count)
+	}
+
+	transport := newTCPTransport(simulator.Addr(), time.Second)
+	defer transport.Close()
+	registers, err := transport.ReadHoldingRegisters(1, start, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := decodeRegisters(device, start, registers)
+
+	near := func(name string, got float32, want float64) {
+		t.Helper()
+		if math.Abs(float64(got)-want) > 1e-3 {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	near("X.Acceleration", data.X.Acceleration, 8*9.8)
+	near("Z.Acceleration", data.Z.Acceleration, -8*9.8)
+	near("X.VibrationSpeed", data.X.VibrationSpeed, 12)
+	near("Temperature", data.Temperature, -2.5)
+	near("Z.Frequency", data.Z.Frequency, 50.5)
+	near("Z.VibrationDisplacement", data.Z.VibrationDisplacement, math.MaxUint16-100)
+	if data.DeviceAddress != "sim-1" || !data.ModbusHighSpeed {
+		t.Errorf("reading = %+v, want sim-1 with ModbusHighSpeed", data)
+	}
+}
+
+func TestRegisterBlockTooWide(t *testing.T) {
+	registers := map[string]Register{
+		"X.Acceleration": {Address: 0},
+		"Y.Acceleration": {Address: maxRegisters},
+	}
+	if _, _, err := registerBlock(registers); err == nil {
+		t.Fatal("a block wider than one read was accepted")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"default registers", `{"Devices": [{"DeviceAddress": "a", "Transport": "rtu", "Address": "/dev/ttyUSB0"}]}`, ""},
+		{"unknown transport", `{"Devices": [{"DeviceAddress": "a", "Transport": "udp", "Address": "x"}]}`, "unknown transport"},
+		{"unknown field", `{"Devices": [{"DeviceAddress": "a", "Transport": "tcp", "Address": "x", "Registers": {"W.Acceleration": {"Address": 1}}}]}`, "unknown field"},
+		{"no address", `{"Devices": [{"DeviceAddress": "a", "Transport": "tcp"}]}`, "needs DeviceAddress and Address"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "modbus.json")
+		if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(path)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err == "" && len(config.Devices[0].Registers) != len(WitMotionRegisters()):
+			t.Errorf("%s: registers = %v, want the WitMotion map", test.name, config.Devices[0].Registers)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
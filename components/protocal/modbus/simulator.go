@@ -0,0 +1,169 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Simulator is a Modbus slave answering with WitMotion-like vibration readings, over TCP or RTU framing.
+// It lets the poller be exercised without real hardware; set MODBUS_SIMULATOR to a listen address to start it.
+type Simulator struct {
+	address  string
+	listener net.Listener
+	started  time.Time
+
+	// Registers overrides the generated value of individual holding registers
+	Registers map[uint16]uint16
+	mutex     sync.Mutex
+}
+
+// NewSimulator creates a simulator listening on address, e.g. "127.0.0.1:5020"
+func NewSimulator(address string) *Simulator {
+	return &Simulator{address: address, Registers: make(map[uint16]uint16)}
+}
+
+// Start listens and serves connections in the background
+func (s *Simulator) Start() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.started = time.Now()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	logger.Info("Modbus simulator listening", "address", listener.Addr().String())
+	return nil
+}
+
+// Addr returns the address the simulator listens on
+func (s *Simulator) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the simulator
+func (s *Simulator) Close() error {
+	return s.listener.Close()
+}
+
+// SetRegister fixes the value of a holding register
+func (s *Simulator) SetRegister(address uint16, value uint16) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Registers[address] = value
+}
+
+func (s *Simulator) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if err != io.EOF {
+				logger.Warn("Modbus simulator read error", "error", err)
+			}
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if length < 2 || length > 256 {
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+
+		response := s.handle(pdu)
+		binary.BigEndian.PutUint16(header[4:], uint16(len(response)+1))
+		if _, err := conn.Write(append(header, response...)); err != nil {
+			return
+		}
+	}
+}
+
+// ServeRTU answers RTU frames addressed to unitID on a serial line until it fails, e.g. one end of a pipe.
+// Like a real slave it stays silent on frames with a bad CRC or for another unit.
+func (s *Simulator) ServeRTU(line io.ReadWriter, unitID byte) error {
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+	for {
+		// * every request the poller sends is 8 bytes: unit, function, two 16-bit fields and the CRC
+		frame := make([]byte, 8)
+		if _, err := io.ReadFull(line, frame); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint16(frame[6:]) != crc16(frame[:6]) || frame[0] != unitID {
+			continue
+		}
+
+		response := append([]byte{unitID}, s.handle(frame[1:6])...)
+		response = binary.LittleEndian.AppendUint16(response, crc16(response))
+		if _, err := line.Write(response); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers one request PDU
+func (s *Simulator) handle(pdu []byte) []byte {
+	if pdu[0] != readHoldingRegisters {
+		return []byte{pdu[0] | 0x80, 0x01} // illegal function
+	}
+	if len(pdu) != 5 {
+		return []byte{pdu[0] | 0x80, 0x03} // illegal data value
+	}
+	start := binary.BigEndian.Uint16(pdu[1:])
+	count := binary.BigEndian.Uint16(pdu[3:])
+	if count == 0 || count > maxRegisters || int(start)+int(count) > 0x10000 {
+		return []byte{pdu[0] | 0x80, 0x02} // illegal data address
+	}
+
+	response := []byte{readHoldingRegisters, byte(count * 2)}
+	for i := uint16(0); i < count; i++ {
+		response = binary.BigEndian.AppendUint16(response, s.register(start+i))
+	}
+	return response
+}
+
+// register returns the raw value of a holding register, slowly oscillating like a running machine
+func (s *Simulator) register(address uint16) uint16 {
+	s.mutex.Lock()
+	value, ok := s.Registers[address]
+	s.mutex.Unlock()
+	if ok {
+		return value
+	}
+
+	wave := math.Sin(time.Since(s.started).Seconds() + float64(address))
+	switch {
+	case address >= 0x34 && address <= 0x36: // acceleration, around 0.1 g
+		return uint16(int16(wave * 200))
+	case address >= 0x37 && address <= 0x39: // angular velocity
+		return uint16(int16(wave * 50))
+	case address >= 0x3A && address <= 0x3C: // vibration speed in mm/s
+		return uint16(3 + wave*2)
+	case address >= 0x3D && address <= 0x3F: // vibration angle
+		return uint16(int16(wave * 1000))
+	case address == 0x40: // temperature in 0.01 °C
+		return uint16(int16(3000 + wave*100))
+	case address >= 0x41 && address <= 0x43: // displacement in um
+		return uint16(20 + wave*10)
+	case address >= 0x44 && address <= 0x46: // frequency in 0.1 Hz
+		return uint16(500 + wave*5)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,237 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Modbus function code for reading holding registers, the only one the sensors need
+const readHoldingRegisters = 0x03
+
+// Most registers a single read may return
+const maxRegisters = 125
+
+// transport reads a block of holding registers from one slave
+type transport interface {
+	ReadHoldingRegisters(unitID byte, start uint16, count uint16) ([]uint16, error)
+	Close() error
+}
+
+// * Modbus TCP
+
+type tcpTransport struct {
+	address     string
+	timeout     time.Duration
+	conn        net.Conn
+	transaction uint16
+	mutex       sync.Mutex
+}
+
+func newTCPTransport(address string, timeout time.Duration) *tcpTransport {
+	return &tcpTransport{address: address, timeout: timeout}
+}
+
+func (t *tcpTransport) ReadHoldingRegisters(unitID byte, start uint16, count uint16) ([]uint16, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.conn == nil {
+		conn, err := net.DialTimeout("tcp", t.address, t.timeout)
+		if err != nil {
+			return nil, err
+		}
+		t.conn = conn
+	}
+
+	registers, err := t.read(unitID, start, count)
+	if err != nil {
+		// drop the connection so the next poll reconnects
+		t.conn.Close()
+		t.conn = nil
+	}
+	return registers, err
+}
+
+func (t *tcpTransport) read(unitID byte, start uint16, count uint16) ([]uint16, error) {
+	t.transaction++
+	if err := t.conn.SetDeadline(time.Now().Add(t.timeout)); err != nil {
+		return nil, err
+	}
+
+	// MBAP header (transaction, protocol 0, length) followed by unit id and PDU
+	request := make([]byte, 12)
+	binary.BigEndian.PutUint16(request[0:], t.transaction)
+	binary.BigEndian.PutUint16(request[4:], 6)
+	request[6] = unitID
+	request[7] = readHoldingRegisters
+	binary.BigEndian.PutUint16(request[8:], start)
+	binary.BigEndian.PutUint16(request[10:], count)
+	if _, err := t.conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(t.conn, header); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(header[0:]) != t.transaction {
+		return nil, errors.New("modbus tcp: transaction id mismatch")
+	}
+	length := binary.BigEndian.Uint16(header[4:])
+	if length < 2 || length > 256 {
+		return nil, fmt.Errorf("modbus tcp: invalid length %d", length)
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(t.conn, pdu); err != nil {
+		return nil, err
+	}
+	return parseResponse(pdu, count)
+}
+
+func (t *tcpTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+// * Modbus RTU over a serial device. The line (baud rate, parity) must be configured
+// * beforehand, e.g. with `stty -F /dev/ttyUSB0 9600 raw`.
+
+type rtuTransport struct {
+	timeout time.Duration
+	port    io.ReadWriteCloser
+	// open opens the line, the serial device unless a test connects the simulator
+	open  func() (io.ReadWriteCloser, error)
+	mutex sync.Mutex
+}
+
+func newRTUTransport(path string, timeout time.Duration) *rtuTransport {
+	return &rtuTransport{timeout: timeout, open: func() (io.ReadWriteCloser, error) {
+		return os.OpenFile(path, os.O_RDWR, 0)
+	}}
+}
+
+func (t *rtuTransport) ReadHoldingRegisters(unitID byte, start uint16, count uint16) ([]uint16, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.port == nil {
+		port, err := t.open()
+		if err != nil {
+			return nil, err
+		}
+		t.port = port
+	}
+
+	registers, err := t.read(unitID, start, count)
+	if err != nil {
+		t.port.Close()
+		t.port = nil
+	}
+	return registers, err
+}
+
+func (t *rtuTransport) read(unitID byte, start uint16, count uint16) ([]uint16, error) {
+	// without a deadline a silent slave would block the read, and the polling of the device, forever
+	port, ok := t.port.(interface{ SetDeadline(time.Time) error })
+	if !ok {
+		return nil, errors.New("modbus rtu: the line has no read timeout")
+	}
+	if err := port.SetDeadline(time.Now().Add(t.timeout)); err != nil {
+		return nil, fmt.Errorf("modbus rtu: setting the read timeout: %w", err)
+	}
+
+	request := make([]byte, 6, 8)
+	request[0] = unitID
+	request[1] = readHoldingRegisters
+	binary.BigEndian.PutUint16(request[2:], start)
+	binary.BigEndian.PutUint16(request[4:], count)
+	request = binary.LittleEndian.AppendUint16(request, crc16(request))
+	if _, err := t.port.Write(request); err != nil {
+		return nil, err
+	}
+
+	// unit, function, byte count (or exception code)
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(t.port, header); err != nil {
+		return nil, err
+	}
+	remaining := 2 // CRC of an exception response
+	if header[1] == readHoldingRegisters {
+		remaining = int(header[2]) + 2
+	}
+	rest := make([]byte, remaining)
+	if _, err := io.ReadFull(t.port, rest); err != nil {
+		return nil, err
+	}
+
+	frame := append(header, rest...)
+	body := frame[:len(frame)-2]
+	if binary.LittleEndian.Uint16(frame[len(frame)-2:]) != crc16(body) {
+		return nil, errors.New("modbus rtu: crc mismatch")
+	}
+	if body[0] != unitID {
+		return nil, fmt.Errorf("modbus rtu: response from unit %d, expected %d", body[0], unitID)
+	}
+	return parseResponse(body[1:], count)
+}
+
+func (t *rtuTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.port == nil {
+		return nil
+	}
+	err := t.port.Close()
+	t.port = nil
+	return err
+}
+
+// parseResponse decodes a read holding registers PDU (function, byte count, data)
+func parseResponse(pdu []byte, count uint16) ([]uint16, error) {
+	if len(pdu) < 2 {
+		return nil, errors.New("modbus: short response")
+	}
+	if pdu[0] == readHoldingRegisters|0x80 {
+		return nil, fmt.Errorf("modbus: exception code %d", pdu[1])
+	}
+	if pdu[0] != readHoldingRegisters {
+		return nil, fmt.Errorf("modbus: unexpected function code %d", pdu[0])
+	}
+	if int(pdu[1]) != int(count)*2 || len(pdu) != 2+int(count)*2 {
+		return nil, fmt.Errorf("modbus: expected %d registers, got %d bytes", count, pdu[1])
+	}
+
+	registers := make([]uint16, count)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(pdu[2+i*2:])
+	}
+	return registers, nil
+}
+
+// crc16 is the Modbus RTU checksum (polynomial 0xA001, initial value 0xFFFF)
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -1,45 +1,84 @@
 package mosquitto
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
 
-	"GOLANG_SERVER/components/db"
-	"GOLANG_SERVER/components/env"
-	schema "GOLANG_SERVER/components/schema"
+	"GOLANG_SERVER/components/codec"
+	"GOLANG_SERVER/components/config"
+	"GOLANG_SERVER/components/ingest"
+	"GOLANG_SERVER/components/logging"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+var logger = logging.For("mosquitto")
+
+// * whether the client is connected to the broker, kept up to date by the client's callbacks
+var connected atomic.Bool
+
+// HealthCheck reports the broker connection for /readyz
+func HealthCheck(ctx context.Context) (any, error) {
+	if !connected.Load() {
+		return nil, errors.New("not connected to the broker")
+	}
+	return nil, nil
+}
+
 // Handle MQTT connections and messages
-func HandleMQTT() {
+func HandleMQTT(mqttConfig config.MQTT) {
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(env.GetEnv("MQTT_BROKER"))
-	opts.SetClientID("go_mqtt_client")
-	opts.SetUsername(env.GetEnv("MQTT_USERNAME"))
-	opts.SetPassword(env.GetEnv("MQTT_PASSWORD"))
+	opts.AddBroker(mqttConfig.Broker)
+	opts.SetClientID(mqttConfig.ClientID)
+	opts.SetUsername(mqttConfig.Username)
+	opts.SetPassword(mqttConfig.Password)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		connected.Store(true)
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		connected.Store(false)
+		logger.Warn("MQTT connection lost", "error", err)
+	})
 
 	client := mqtt.NewClient(opts)
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatal(token.Error())
+		logger.Error("Can't connect to the MQTT broker", "error", token.Error())
+		os.Exit(1)
 	}
 
-	if token := client.Subscribe("sample", 1, func(client mqtt.Client, msg mqtt.Message) {
-		// fmt.Printf("Received message: %s from topic: %s\n", msg.Payload(), msg.Topic())
-		// Process the message and store it in the database
-		var data schema.GyroData
-		if err := json.Unmarshal(msg.Payload(), &data); err != nil {
-			log.Println("Error unmarshaling message:", err)
+	// * "sample" carries JSON, "sample/cbor" and "sample/packed" the compact binary formats.
+	// * Tenants publish on "<prefix>/sample..." and may only publish for their own devices.
+	topics := map[string]byte{"sample": 1, "sample/+": 1, "+/sample": 1, "+/sample/+": 1}
+	if token := client.SubscribeMultiple(topics, func(client mqtt.Client, msg mqtt.Message) {
+		logger.Debug("Received message", "topic", msg.Topic(), "bytes", len(msg.Payload()))
+		organization, err := topicOrganization(msg.Topic())
+		if err != nil {
+			logger.Warn("Can't resolve topic", "topic", msg.Topic(), "error", err)
 			return
 		}
-		if _, err := db.StoreGyroData(data); err != nil {
-			log.Println("Error storing data in database:", err)
+
+		// Validate the message and store it in the database
+		format := codec.FormatFromTopic(msg.Topic())
+		if _, err := ingest.Store(organization, ingest.SourceMQTT, format, msg.Payload()); err != nil {
+			logger.Warn("Error storing message", "topic", msg.Topic(), "error", err)
 		}
 
 	}); token.Wait() && token.Error() != nil {
-		log.Fatal(token.Error())
+		logger.Error("Can't subscribe to the MQTT topics", "error", token.Error())
+		os.Exit(1)
 	}
 
-	fmt.Println("MQTT client connected and subscribed to topic.")
+	logger.Info("MQTT client connected and subscribed to topic", "broker", mqttConfig.Broker)
+}
+
+// topicOrganization returns the organization owning a topic's prefix, "" for the unprefixed "sample" topics
+func topicOrganization(topic string) (string, error) {
+	prefix, _, _ := strings.Cut(topic, "/")
+	if prefix == "sample" {
+		return "", nil
+	}
+	return ingest.PrefixOrganization(prefix)
 }
@@ -0,0 +1,110 @@
+package rest
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/export"
+	"GOLANG_SERVER/components/response"
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// Largest number of audit entries returned at once, CSV exports included
+const maxAuditEntries = 100000
+
+// * list the organization's audit log, newest first, as JSON or as CSV (?format=csv or Accept: text/csv)
+func HandleGetAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	// Optional filters: ?actor=&action=&target=&outcome=&from=&to=&limit=
+	query := r.URL.Query()
+	filter := db.AuditFilter{
+		Actor:   query.Get("actor"),
+		Action:  query.Get("action"),
+		Target:  query.Get("target"),
+		Outcome: query.Get("outcome"),
+		Limit:   100,
+	}
+	var err error
+	if filter.From, err = parseTime(query.Get("from")); err != nil {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid from: "+err.Error())
+		return
+	}
+	if filter.To, err = parseTime(query.Get("to")); err != nil {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid to: "+err.Error())
+		return
+	}
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 || parsed > maxAuditEntries {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid limit")
+			return
+		}
+		filter.Limit = parsed
+	}
+
+	entries, err := db.GetAudit(auth.Organization(r), filter)
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	if query.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		if err := writeAuditCSV(w, entries); err != nil {
+			// * the header is sent already, the client sees a cut-off file
+			logger.WarnContext(r.Context(), "Error writing the audit log as CSV", "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response.JSON(w, http.StatusOK, entries)
+}
+
+// writeAuditCSV writes the entries as CSV. Actors and targets can be typed by anyone, e.g. the email of a
+// failed login, so text cells are kept from being read as formulas.
+func writeAuditCSV(w io.Writer, entries []schema.AuditEntry) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"DateTime", "TimeStamp", "Actor", "IP", "Action", "Target", "Outcome", "Organization"}); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		record := []string{entry.DateTime, strconv.FormatInt(entry.TimeStamp, 10), entry.Actor, entry.IP,
+			entry.Action, entry.Target, entry.Outcome, entry.Organization}
+		for i := range record {
+			record[i] = export.Cell(record[i])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// parseTime reads an RFC 3339 time or Unix milliseconds; empty means no bound
+func parseTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return millis, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, errors.New("use RFC 3339 or Unix milliseconds")
+	}
+	return parsed.UnixMilli(), nil
+}
@@ -0,0 +1,158 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"GOLANG_SERVER/components/ingest"
+	"GOLANG_SERVER/components/response"
+)
+
+// Largest accepted bulk request body after decompression
+const maxBulkBytes = 256 << 20
+
+// BulkResponse summarises a bulk request; Results only lists the readings that were not stored
+type BulkResponse struct {
+	Received   int             `json:"Received"`
+	Stored     int             `json:"Stored"`
+	Duplicates int             `json:"Duplicates"`
+	Rejected   int             `json:"Rejected"`
+	Failed     int             `json:"Failed"`
+	Error      string          `json:"Error,omitempty"`
+	Results    []ingest.Result `json:"Results"`
+}
+
+// * store many readings sent as a JSON array or as newline-delimited JSON, optionally gzip-compressed
+func HandleStoreBulk(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			response.BadRequest(w, err)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	// * the limit applies after decompression, a small gzip body can't expand without bound
+	reader := bufio.NewReader(http.MaxBytesReader(w, io.NopCloser(body), maxBulkBytes))
+
+	batch := ingest.NewBatch("", ingest.SourceREST, bulkBatchSize)
+
+	// * a JSON array starts with '[', anything else is read as one reading per line
+	var err error
+	if first, peekErr := peekNonSpace(reader); peekErr == nil && first == '[' {
+		err = readArray(reader, batch)
+	} else {
+		err = readLines(reader, batch)
+	}
+	batch.Flush()
+
+	reply := BulkResponse{
+		Received:   len(batch.Results),
+		Stored:     batch.Count(ingest.StatusStored),
+		Duplicates: batch.Count(ingest.StatusDuplicate),
+		Rejected:   batch.Count(ingest.StatusRejected),
+		Failed:     batch.Count(ingest.StatusFailed),
+		Results:    []ingest.Result{},
+	}
+	for _, result := range batch.Results {
+		if result.Status != ingest.StatusStored {
+			reply.Results = append(reply.Results, result)
+		}
+	}
+
+	status := http.StatusOK
+	switch {
+	case tooLarge(err):
+		// the readings before the limit were processed, the client sends the rest in another request
+		reply.Error = fmt.Sprintf("the body is larger than %d bytes", maxBulkBytes)
+		status = http.StatusRequestEntityTooLarge
+	case err != nil:
+		// the readings before the malformed part were processed, tell the client where it stopped
+		reply.Error = err.Error()
+		status = http.StatusBadRequest
+	}
+	logger.InfoContext(r.Context(), "Bulk request",
+		"received", reply.Received,
+		"stored", reply.Stored,
+		"duplicates", reply.Duplicates,
+		"rejected", reply.Rejected,
+		"failed", reply.Failed,
+	)
+
+	response.JSON(w, status, reply)
+}
+
+// readArray streams the elements of a JSON array into the batch
+func readArray(reader io.Reader, batch *ingest.Batch) error {
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	for decoder.More() {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("item %d: %w", len(batch.Results), err)
+		}
+		batch.Add(raw)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readLines adds every non-empty line to the batch; a malformed line is rejected on its own
+func readLines(reader io.Reader, batch *ingest.Batch) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	// * a line is added once the next one is read: the last line of a body cut off at the limit is incomplete
+	var pending []byte
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if pending != nil {
+			batch.Add(pending)
+		}
+		pending = bytes.Clone(line)
+	}
+	err := scanner.Err()
+	if pending != nil && !tooLarge(err) {
+		batch.Add(pending)
+	}
+	return err
+}
+
+// tooLarge reports whether reading stopped at the limit of the body
+func tooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package rest
+
+import (
+	"archive/zip"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/export"
+	"GOLANG_SERVER/components/response"
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// Device addresses that can be put in a file name as they are
+var fileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// * export readings as CSV or XLSX with one column per field (X.VibrationSpeed, ...).
+// * Optional: ?device=&from=&to=&columns=DateTime,X.VibrationSpeed&tz=Asia/Bangkok&format=xlsx
+func HandleExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	filter := db.ReadingFilter{DeviceAddress: query.Get("device")}
+	var err error
+	if filter.From, err = parseTime(query.Get("from")); err != nil {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid from: "+err.Error())
+		return
+	}
+	if filter.To, err = parseTime(query.Get("to")); err != nil {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid to: "+err.Error())
+		return
+	}
+
+	var names []string
+	for _, name := range strings.Split(query.Get("columns"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	columns, err := export.Select(names)
+	if err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+
+	// * times are shown in the requested zone, else in the user's own, else in UTC
+	user, _ := auth.UserFrom(r)
+	zone := query.Get("tz")
+	if zone == "" {
+		zone = user.Timezone
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Unknown timezone, use an IANA name such as Asia/Bangkok")
+		return
+	}
+
+	writer, err := export.New(format, w)
+	if err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+
+	contentType, extension := export.ContentType(format)
+	fileName := "readings"
+	if fileNamePattern.MatchString(filter.DeviceAddress) {
+		fileName += "-" + filter.DeviceAddress
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+extension+`"`)
+
+	// * rows go out as they are read from the database, an export of any size uses the same memory
+	rows := 0
+	err = writer.Header(columns)
+	if err == nil {
+		err = db.EachGyroData(r.Context(), auth.Organization(r), filter, func(data schema.GyroData) error {
+			rows++
+			return writer.Row(export.Row(columns, data, location))
+		})
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil && rows == 0 {
+		// * nothing has left the writers' buffers yet, the client still gets an error response
+		w.Header().Del("Content-Disposition")
+		response.Err(w, r, err)
+		return
+	}
+	if err != nil {
+		// * the status is sent already; dropping the connection tells the client the file is incomplete
+		if r.Context().Err() == nil {
+			logger.ErrorContext(r.Context(), "Export failed", "rows", rows, "error", err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+	logger.InfoContext(r.Context(), "Readings exported", "format", format, "device", filter.DeviceAddress, "rows", rows)
+}
+
+// * export readings as a zip of Parquet files, one per device and day ("device=a1/date=2024-01-31/readings.parquet"),
+// * the layout of the archive command. Optional: ?device=&from=&to=
+func HandleExportParquet(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := db.ReadingFilter{DeviceAddress: query.Get("device"), ByDevice: true}
+	var err error
+	if filter.From, err = parseTime(query.Get("from")); err != nil {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid from: "+err.Error())
+		return
+	}
+	if filter.To, err = parseTime(query.Get("to")); err != nil {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid to: "+err.Error())
+		return
+	}
+
+	fileName := "readings"
+	if fileNamePattern.MatchString(filter.DeviceAddress) {
+		fileName += "-" + filter.DeviceAddress
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`-parquet.zip"`)
+
+	archive := zip.NewWriter(w)
+	partitions := 0
+	partitioner := &export.Partitioner{
+		Open: func(partition export.Partition) (io.WriteCloser, error) {
+			partitions++
+			file, err := archive.CreateHeader(&zip.FileHeader{
+				Name: partition.Path() + "/readings.parquet", Method: zip.Store, Modified: time.Now(),
+			})
+			return zipFile{file}, err
+		},
+	}
+
+	rows := 0
+	err = db.EachGyroData(r.Context(), auth.Organization(r), filter, func(data schema.GyroData) error {
+		rows++
+		return partitioner.Write(data)
+	})
+	if closeErr := partitioner.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil && rows == 0 {
+		w.Header().Del("Content-Disposition")
+		response.Err(w, r, err)
+		return
+	}
+	if err != nil {
+		if r.Context().Err() == nil {
+			logger.ErrorContext(r.Context(), "Export failed", "rows", rows, "error", err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+	logger.InfoContext(r.Context(), "Readings exported", "format", "parquet", "device", filter.DeviceAddress, "rows", rows, "partitions", partitions)
+}
+
+// zipFile is a zip entry, stored as it is since Parquet pages are compressed already.
+// It is complete once the next one is created or the zip is closed.
+type zipFile struct {
+	io.Writer
+}
+
+func (zipFile) Close() error {
+	return nil
+}
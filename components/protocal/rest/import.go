@@ -0,0 +1,146 @@
+package rest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/ingest"
+	"GOLANG_SERVER/components/response"
+)
+
+// * import historical readings from a CSV or NDJSON file, keeping their own time.
+// * The file is the body or the "file" field of a form. Optional: ?format=&map=ts=TimeStamp,ax=X.Acceleration&device=&tz=&delimiter=;&progress=true
+func HandleImport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	body, name, err := importFile(r)
+	if err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	defer body.Close()
+
+	options := ingest.ImportOptions{
+		Format:       query.Get("format"),
+		Device:       query.Get("device"),
+		Organization: auth.Organization(r),
+		BatchSize:    bulkBatchSize,
+	}
+	if options.Format == "" {
+		options.Format = importFormat(r, name)
+	}
+	if options.Mapping, err = ingest.ParseMapping(query.Get("map")); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	if zone := query.Get("tz"); zone != "" {
+		if options.Location, err = time.LoadLocation(zone); err != nil {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Unknown timezone, use an IANA name such as Asia/Bangkok")
+			return
+		}
+	}
+	if delimiter := query.Get("delimiter"); delimiter != "" {
+		if utf8.RuneCountInString(delimiter) != 1 {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "The delimiter must be one character")
+			return
+		}
+		options.Delimiter, _ = utf8.DecodeRuneInString(delimiter)
+	}
+
+	// * with ?progress=true the report so far is sent as a line of NDJSON while the import runs, the last line has Done set
+	progress := query.Get("progress") == "true"
+	encoder := json.NewEncoder(w)
+	if progress {
+		controller := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		options.Progress = func(report ingest.ImportReport) {
+			encoder.Encode(report)
+			controller.Flush()
+		}
+	}
+
+	user, _ := auth.UserFrom(r)
+	report, err := ingest.Import(r.Context(), body, options)
+	logger.InfoContext(r.Context(), "Readings imported",
+		"file", name,
+		"format", options.Format,
+		"received", report.Received,
+		"stored", report.Stored,
+		"duplicates", report.Duplicates,
+		"rejected", report.Rejected,
+		"failed", report.Failed,
+	)
+	outcome := audit.OutcomeSuccess
+	if err != nil {
+		outcome = audit.OutcomeFailure
+	}
+	audit.Record(r, user.Email, audit.ActionDataImport, name, outcome)
+
+	if progress {
+		encoder.Encode(report)
+		return
+	}
+	status := http.StatusOK
+	if err != nil {
+		// the readings before the problem were imported, the report tells the client where it stopped
+		status = http.StatusBadRequest
+	}
+	response.JSON(w, status, report)
+}
+
+// importFile returns the uploaded file and its name: the "file" field of a multipart form, else the body
+func importFile(r *http.Request) (io.ReadCloser, string, error) {
+	var file io.ReadCloser = r.Body
+	name := "upload"
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		form, err := r.MultipartReader()
+		if err != nil {
+			return nil, "", err
+		}
+		for {
+			part, err := form.NextPart()
+			if err == io.EOF {
+				return nil, "", errors.New(`the form has no "file" field`)
+			}
+			if err != nil {
+				return nil, "", err
+			}
+			if part.FormName() == "file" {
+				file, name = part, part.FileName()
+				break
+			}
+			part.Close()
+		}
+	}
+
+	if r.Header.Get("Content-Encoding") == "gzip" || ingest.Compressed(name) {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, "", err
+		}
+		return gz, name, nil
+	}
+	return file, name, nil
+}
+
+// importFormat guesses the format of an upload without ?format= from its file name or content type
+func importFormat(r *http.Request, name string) string {
+	if format := ingest.ImportFormatOf(name); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.Contains(mediaType, "json") {
+		return ingest.ImportNDJSON
+	}
+	return ingest.ImportCSV
+}
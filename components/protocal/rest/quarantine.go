@@ -0,0 +1,77 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/ingest"
+	"GOLANG_SERVER/components/response"
+)
+
+// * list quarantined payloads, newest first
+func HandleGetQuarantine(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	// Optional filters: ?source=mqtt&limit=50
+	source := r.URL.Query().Get("source")
+	limit := int64(100)
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	records, err := db.GetQuarantine(auth.Organization(r), source, limit)
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, records)
+}
+
+// * replay a quarantined payload through validation and store it if it passes
+func HandleReplayQuarantine(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	// Get the quarantine id from the URL
+	id := r.PathValue("id")
+
+	record, err := db.GetQuarantineByID(auth.Organization(r), id)
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	user, _ := auth.UserFrom(r)
+	if err := ingest.Replay(record); err != nil {
+		audit.Record(r, user.Email, audit.ActionQuarantineReplay, id, audit.OutcomeFailure)
+		if errors.Is(err, ingest.ErrRejected) {
+			response.Error(w, http.StatusUnprocessableEntity, response.CodeValidation, err.Error())
+		} else {
+			response.Err(w, r, err)
+		}
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Replayed quarantined payload", "id", id)
+	audit.Record(r, user.Email, audit.ActionQuarantineReplay, id, audit.OutcomeSuccess)
+	response.Message(w, http.StatusOK, "Data stored!")
+}
@@ -2,101 +2,103 @@ package rest
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
+	"io"
 	"net/http"
 
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/codec"
 	"GOLANG_SERVER/components/db"
-	"GOLANG_SERVER/components/env"
+	"GOLANG_SERVER/components/ingest"
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/response"
 	schema "GOLANG_SERVER/components/schema"
+	"GOLANG_SERVER/components/user"
+)
+
+var logger = logging.For("rest")
 
-	"go.mongodb.org/mongo-driver/mongo"
+// * settings injected by main
+var (
+	cleanPassword string
+	bulkBatchSize = 500
 )
 
+// Configure sets the operator password, needed to clean the default organization, and how many readings of a bulk request are inserted at once
+func Configure(password string, batchSize int) {
+	cleanPassword = password
+	bulkBatchSize = batchSize
+}
+
 func HandleRegisterDevice(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json") // Set the content type to JSON
 
 	// Get the device address from the json request
 	deviceAddress := r.URL.Query().Get("deviceAddress")
 	if deviceAddress == "" {
-		http.Error(w, "Device address not found", http.StatusBadRequest)
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "deviceAddress is required")
 		return
 	}
 
-	// display device address
-	log.Println("Device Address:", deviceAddress)
+	logger.DebugContext(r.Context(), "Registering device", "device", deviceAddress)
 
 	// store device address to database
-	if _, err := db.RegisterDevice(deviceAddress); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	user, _ := auth.UserFrom(r)
+	if _, err := db.RegisterDevice(auth.Organization(r), deviceAddress); err != nil {
+		audit.Record(r, user.Email, audit.ActionDeviceRegister, deviceAddress, audit.OutcomeFailure)
+		response.Err(w, r, err)
 		return
 	}
+	audit.Record(r, user.Email, audit.ActionDeviceRegister, deviceAddress, audit.OutcomeSuccess)
 	// send device address .json to client
-	response := map[string]string{"deviceAddress": deviceAddress}
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	fmt.Fprintf(w, `{"message": "Device registered!"}`)
+	response.JSON(w, http.StatusCreated, map[string]string{"message": "Device registered!", "deviceAddress": deviceAddress})
 }
 
 func HandleGetDeviceAddress(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json") // Set the content type to JSON
 
 	// * get device address from database
-	deviceAddresses, err := db.GetDeviceAddress()
+	deviceAddresses, err := db.GetDeviceAddress(auth.Organization(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Err(w, r, err)
 		return
 	}
 
 	// * send device addresses .json to client
-	response := map[string][]string{"deviceAddresses": deviceAddresses}
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	reply := map[string][]string{"deviceAddresses": deviceAddresses}
+	response.JSON(w, http.StatusOK, reply)
 }
 
 func HandleGetDeviceAddressByDeviceAddress(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json") // Set the content type to JSON
 
 	// Get the device address from the URL
-	deviceAddress := r.URL.Path[len("/checkdeviceaddresses/"):]
-	log.Println("Received request for device address:", deviceAddress)
+	deviceAddress := r.PathValue("device")
+	logger.DebugContext(r.Context(), "Looking up device address", "device", deviceAddress)
 
 	// Get the data from the database
-	deviceAddresses, err := db.GetDeviceAddressByDeviceAddress(deviceAddress)
+	deviceAddresses, err := db.GetDeviceAddressByDeviceAddress(auth.Organization(r), deviceAddress)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, "No documents found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		response.Err(w, r, err)
 		return
 	}
 
 	// Check if the result is empty
 	if len(deviceAddresses) == 0 {
-		log.Println("No device addresses found for:", deviceAddress)
-		http.Error(w, "No device addresses found", http.StatusNotFound)
+		logger.DebugContext(r.Context(), "No device addresses found", "device", deviceAddress)
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, "No device addresses found")
 		return
 	}
 
 	// Encode the data into JSON
-	if err := json.NewEncoder(w).Encode(deviceAddresses); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	log.Println("Successfully retrieved device addresses for:", deviceAddress)
+	response.JSON(w, http.StatusOK, deviceAddresses)
 }
 
 // Handle a REST API request
 func HandleAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"message": "Hello from REST API!"}`)
+	response.Message(w, http.StatusOK, "Hello from REST API!")
 }
 
 // Handle a request for the schema
@@ -104,38 +106,74 @@ func HandleGetAllData(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Get the data from the database
-	data, err := db.GetGyroData()
+	data, err := db.GetGyroData(auth.Organization(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Err(w, r, err)
 		return
 	}
 
 	// Encode the data into JSON
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	response.JSON(w, http.StatusOK, data)
 }
 
 // Handle a request to store data
 func HandleStore(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Decode the request body into a GyroData struct
-	var data schema.GyroData
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// Read the raw request body, it is kept as-is if the payload gets quarantined
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+
+	// * the Content-Type selects JSON, CBOR or the packed binary layout
+	format := codec.FormatFromContentType(r.Header.Get("Content-Type"))
+
+	// * only the size is logged, payloads would flood the log at high ingest rates
+	logger.DebugContext(r.Context(), "Received data", "format", format, "bytes", len(body))
+
+	// Validate and store the data in the database
+	stored, err := ingest.Store("", ingest.SourceREST, format, body)
+	if err != nil {
+		if errors.Is(err, ingest.ErrRejected) {
+			logger.InfoContext(r.Context(), "Data rejected", "error", err)
+			response.Error(w, http.StatusUnprocessableEntity, response.CodeValidation, err.Error())
+		} else {
+			logger.ErrorContext(r.Context(), "Error storing data", "error", err)
+			response.Err(w, r, err)
+		}
+		return
+	}
+
+	if !stored {
+		// * a retried reading is acknowledged so the gateway stops resending it
+		response.Message(w, http.StatusOK, "Duplicate data ignored!")
 		return
 	}
+	response.Message(w, http.StatusOK, "Data stored!")
+}
 
-	// * print the data
-	fmt.Printf("Data: %+v\n", data) // Print the data
+// * get the number of suppressed duplicate readings per device
+func HandleGetDuplicates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
-	// Store the data in the database
-	db.StoreGyroData(data)
+	// * only report the organization's own devices
+	deviceAddresses, err := db.GetDeviceAddress(auth.Organization(r))
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+	counts := db.GetDuplicateCounts()
+	duplicates := make(map[string]int64)
+	for _, deviceAddress := range deviceAddresses {
+		if count, ok := counts[deviceAddress]; ok {
+			duplicates[deviceAddress] = count
+		}
+	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"message": "Data stored!"}`)
+	reply := map[string]map[string]int64{"duplicates": duplicates}
+	response.JSON(w, http.StatusOK, reply)
 }
 
 // * get data use param
@@ -143,22 +181,19 @@ func HandleGetAllDataByDeviceAddress(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Get the device address from the URL
-	deviceAddress := r.URL.Path[len("/data/"):]
-	fmt.Println("Device Address:", deviceAddress)
+	deviceAddress := r.PathValue("device")
+	logger.DebugContext(r.Context(), "Getting data", "device", deviceAddress)
 
 	// Get the data from the database
-	data, err := db.GetGyroDataByDeviceAddress(deviceAddress)
+	data, err := db.GetGyroDataByDeviceAddress(auth.Organization(r), deviceAddress)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Err(w, r, err)
 		return
 	}
 
 	// Encode the data into JSON
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	response.JSON(w, http.StatusOK, data)
 }
 
 // * get latest data
@@ -169,56 +204,69 @@ func HandleGetLatestData(w http.ResponseWriter, r *http.Request) {
 		// * get data from request
 		var req schema.GyroData
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			response.BadRequest(w, err)
+			return
+		}
+		if req.DeviceAddress == "" {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "DeviceAddress is required")
+			return
 		}
 
 		// Get the data from the database
-		data, err := db.GetGyroDataByDeviceAddressLatest(req.DeviceAddress)
+		data, err := db.GetGyroDataByDeviceAddressLatest(auth.Organization(r), req.DeviceAddress)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			response.Err(w, r, err)
 			return
 		}
 
 		// Encode the data into JSON
-		if err := json.NewEncoder(w).Encode(data); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		response.JSON(w, http.StatusOK, data)
 	} else {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		response.MethodNotAllowed(w)
 	}
 }
 
+// * admins confirm with their own password; the default organization is the operator's, it also takes PASSWORD
 func HandleCleanData(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	account, _ := auth.UserFrom(r)
+	organization := auth.Organization(r)
+
 	// Get the password from client
 	if r.Method == "POST" {
 		var req schema.PasswordRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			response.BadRequest(w, err)
 			return
 		}
 
 		// * check password
 		if req.Password == req.CFP {
-			if req.Password == env.GetEnv("PASSWORD") {
-				// * clean data
-				if _, err := db.CleanData(); err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-
-				w.WriteHeader(http.StatusOK)
-				fmt.Fprintf(w, `{"message": "Data cleaned!"}`)
-			} else {
-				http.Error(w, "Invalid password", http.StatusUnauthorized)
+			if !user.CheckPassword(w, r, account, req.Password) {
+				audit.Record(r, account.Email, audit.ActionDataClean, organization, audit.OutcomeDenied)
+				return
+			}
+			if organization == schema.DefaultOrganization && (cleanPassword == "" || req.OperatorPassword != cleanPassword) {
+				audit.Record(r, account.Email, audit.ActionDataClean, organization, audit.OutcomeDenied)
+				response.Error(w, http.StatusUnauthorized, response.CodeInvalidCredentials, "Invalid operator password")
+				return
 			}
+
+			// * clean data
+			if _, err := db.CleanData(organization); err != nil {
+				audit.Record(r, account.Email, audit.ActionDataClean, organization, audit.OutcomeFailure)
+				response.Err(w, r, err)
+				return
+			}
+			audit.Record(r, account.Email, audit.ActionDataClean, organization, audit.OutcomeSuccess)
+
+			response.Message(w, http.StatusOK, "Data cleaned!")
 		} else {
-			http.Error(w, "Password doesn't match", http.StatusUnauthorized)
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Password doesn't match")
 		}
 
 	} else {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		response.MethodNotAllowed(w)
 	}
 }
@@ -0,0 +1,46 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/response"
+	schema "GOLANG_SERVER/components/schema"
+	"GOLANG_SERVER/components/validate"
+)
+
+// * get or replace the payload validation rules
+func HandleRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		// * the rules apply to every organization, so only the operator's organization changes them
+		if auth.Organization(r) != schema.DefaultOrganization {
+			response.Error(w, http.StatusForbidden, response.CodeForbidden, "Validation rules can only be changed by the default organization")
+			return
+		}
+
+		var rules validate.Rules
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			response.BadRequest(w, err)
+			return
+		}
+		user, _ := auth.UserFrom(r)
+		if err := validate.SetRules(rules); err != nil {
+			audit.Record(r, user.Email, audit.ActionRulesUpdate, "validation rules", audit.OutcomeFailure)
+			response.BadRequest(w, err)
+			return
+		}
+		logger.InfoContext(r.Context(), "Validation rules updated", "by", user.Email)
+		audit.Record(r, user.Email, audit.ActionRulesUpdate, "validation rules", audit.OutcomeSuccess)
+	default:
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, validate.CurrentRules())
+}
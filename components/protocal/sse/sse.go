@@ -0,0 +1,325 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/events"
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/metrics"
+	"GOLANG_SERVER/components/replay"
+	"GOLANG_SERVER/components/response"
+	schema "GOLANG_SERVER/components/schema"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var logger = logging.For("sse")
+
+// How often a comment is sent on a quiet stream, so proxies keep it open
+const keepAlive = 15 * time.Second
+
+// How long clients wait before reconnecting, in ms
+const retry = 3000
+
+// Most readings sent from the database to a client catching up; it gets the rest after reconnecting
+const maxCatchUp = 10000
+
+// ID of the last event of a replay; EventSource reconnecting with it gets 204 and stops
+const replayEnded = "ended"
+
+// errCaughtUp stops reading from the database once maxCatchUp readings were sent
+var errCaughtUp = errors.New("catch-up limit reached")
+
+// * stream new readings, alerts and device status as Server-Sent Events: "reading" (GyroData), "alert" and "status".
+// * Optional: ?device=A&device=B, every device of the organization by default. Resumes after the Last-Event-ID header or ?lastEventId=
+func HandleStream(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	organization := auth.Organization(r)
+
+	devices := query["device"]
+	for _, device := range devices {
+		visible, err := db.DeviceVisible(organization, device)
+		if err != nil {
+			response.Err(w, r, err)
+			return
+		}
+		if !visible {
+			response.Error(w, http.StatusNotFound, response.CodeNotFound, "Device not found: "+device)
+			return
+		}
+	}
+
+	// * EventSource sends the header when it reconnects, ?lastEventId= is for clients opening a new one
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("lastEventId")
+	}
+	var since time.Time
+	if lastEventID != "" {
+		var err error
+		if _, since, err = events.ParseID(lastEventID); err != nil {
+			response.BadRequest(w, err)
+			return
+		}
+	}
+
+	// * subscribe before catching up, nothing published in between is missed
+	subscription, missed, resumed := events.Subscribe(organization, devices, lastEventID)
+	defer subscription.Close()
+	defer metrics.ClientConnected("sse")()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// * nginx would buffer the events otherwise
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	stream := &stream{w: w, controller: http.NewResponseController(w)}
+	stream.write(fmt.Sprintf("retry: %d\n\n", retry))
+
+	// * readings sent from the database may be published live as well, they are only sent once
+	var caughtUp map[primitive.ObjectID]bool
+	if resumed {
+		for _, event := range missed {
+			stream.send(event.ID, event.Type, event.Data)
+		}
+	} else {
+		sent, err := catchUp(r, stream, organization, devices, since)
+		switch {
+		case errors.Is(err, errCaughtUp):
+			// * ending the stream makes the client reconnect and fetch the next readings
+			stream.flush()
+			return
+		case err != nil:
+			if stream.err == nil && r.Context().Err() == nil {
+				logger.ErrorContext(r.Context(), "Error catching up", "error", err)
+			}
+			return
+		}
+		logger.DebugContext(r.Context(), "Client caught up from the database", "readings", len(sent))
+		caughtUp = sent
+	}
+	if lastEventID == "" || !resumed {
+		// * the current status of the devices, events of their changes before now are not kept
+		for _, status := range events.Statuses(organization, devices) {
+			stream.send("", events.TypeStatus, status)
+		}
+	}
+	stream.flush()
+	if stream.err != nil {
+		return
+	}
+	logger.DebugContext(r.Context(), "Client streaming", "devices", devices, "resumed", lastEventID != "")
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscription.C:
+			if !ok {
+				// * the client fell behind; it reconnects and resumes after the last event it got
+				metrics.Dropped("sse")
+				return
+			}
+			if data, ok := event.Data.(schema.GyroData); ok && caughtUp[data.ID] {
+				delete(caughtUp, data.ID)
+				continue
+			}
+			stream.send(event.ID, event.Type, event.Data)
+		case <-ticker.C:
+			stream.write(": keep-alive\n\n")
+		}
+		stream.flush()
+		if stream.err != nil {
+			logger.DebugContext(r.Context(), "Client disconnected", "error", stream.err)
+			return
+		}
+	}
+}
+
+// catchUp sends the readings stored since a time, for clients resuming after an event the server no longer
+// keeps, e.g. after a restart, and returns the IDs of the readings sent. Their event IDs only carry the time,
+// so a client reconnecting goes on from there. Alerts and status changes of that time are not stored and can't be sent.
+func catchUp(r *http.Request, stream *stream, organization string, devices []string, since time.Time) (map[primitive.ObjectID]bool, error) {
+	sent := make(map[primitive.ObjectID]bool)
+	err := db.EachGyroData(r.Context(), organization, db.ReadingFilter{Devices: devices, From: since.UnixMilli()}, func(data schema.GyroData) error {
+		if len(sent) == maxCatchUp {
+			return errCaughtUp
+		}
+		sent[data.ID] = true
+		// * sequence 0 is never kept, resuming from this ID catches up from the database again
+		stream.send("0-"+strconv.FormatInt(data.TimeStamp, 10), events.TypeReading, data)
+		return stream.err
+	})
+	return sent, err
+}
+
+// stream writes events, remembering the first error so the caller checks once.
+// mutex is for callers writing from more than one goroutine.
+type stream struct {
+	w          http.ResponseWriter
+	controller *http.ResponseController
+	err        error
+	mutex      sync.Mutex
+}
+
+func (s *stream) send(id string, event string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		s.err = err
+		return
+	}
+	message := "event: " + event + "\ndata: " + string(payload) + "\n\n"
+	if id != "" {
+		message = "id: " + id + "\n" + message
+	}
+	s.write(message)
+}
+
+func (s *stream) write(message string) {
+	if s.err == nil {
+		_, s.err = s.w.Write([]byte(message))
+	}
+}
+
+func (s *stream) flush() {
+	if s.err == nil {
+		s.err = s.controller.Flush()
+	}
+}
+
+// * play stored readings as Server-Sent Events: "reading" (GyroData) at their original pacing or Speed times faster,
+// * and "replay" (State) whenever the replay changes. ?from= is required. Optional: ?device=A&device=B&to=&speed=
+// * Controls go to POST /stream/replay/{id} with the ID of the "replay" events.
+func HandleReplay(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	organization := auth.Organization(r)
+
+	request := replay.Request{Devices: query["device"]}
+	var err error
+	for name, value := range map[string]*time.Time{"from": &request.From, "to": &request.To} {
+		if query.Get(name) == "" {
+			continue
+		}
+		if *value, err = replay.ParseTime(query.Get(name)); err != nil {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid "+name+": "+err.Error())
+			return
+		}
+	}
+	if speed := query.Get("speed"); speed != "" {
+		if request.Speed, err = strconv.ParseFloat(speed, 64); err != nil {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid speed: "+err.Error())
+			return
+		}
+	}
+
+	// * the ID of a reading is its time, a client reconnecting goes on from there; it stops after the end
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == replayEnded {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if lastEventID != "" {
+		at, err := replay.ParseTime(lastEventID)
+		if err != nil || at.Before(request.From) {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+		request.From = at
+	}
+
+	for _, device := range request.Devices {
+		visible, err := db.DeviceVisible(organization, device)
+		if err != nil {
+			response.Err(w, r, err)
+			return
+		}
+		if !visible {
+			response.Error(w, http.StatusNotFound, response.CodeNotFound, "Device not found: "+device)
+			return
+		}
+	}
+	player, err := replay.New(organization, request)
+	if err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	defer metrics.ClientConnected("replay")()
+	logger.InfoContext(r.Context(), "Replay started", "devices", request.Devices, "from", request.From, "to", request.To, "speed", request.Speed)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	stream := &stream{w: w, controller: http.NewResponseController(w)}
+	stream.write(fmt.Sprintf("retry: %d\n\n", retry))
+
+	// * a paused replay sends nothing, the keep-alive comments hold the connection open
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stream.mutex.Lock()
+				stream.write(": keep-alive\n\n")
+				stream.flush()
+				stream.mutex.Unlock()
+			}
+		}
+	}()
+
+	err = player.Play(ctx, func(event string, data any) error {
+		stream.mutex.Lock()
+		defer stream.mutex.Unlock()
+		id := ""
+		switch data := data.(type) {
+		case schema.GyroData:
+			id = strconv.FormatInt(data.TimeStamp, 10)
+		case replay.State:
+			if data.State == replay.StateEnded {
+				id = replayEnded
+			}
+		}
+		stream.send(id, event, data)
+		stream.flush()
+		return stream.err
+	})
+	if err != nil && ctx.Err() == nil && stream.err == nil {
+		logger.ErrorContext(r.Context(), "Replay stopped", "error", err)
+	}
+}
+
+// * pause, resume, seek or change the speed of a replay streamed by /stream/replay
+func HandleReplayControl(w http.ResponseWriter, r *http.Request) {
+	player, ok := replay.Find(auth.Organization(r), r.PathValue("id"))
+	if !ok {
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, "Replay not found")
+		return
+	}
+
+	var control replay.Control
+	if err := json.NewDecoder(r.Body).Decode(&control); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	if err := player.Control(control); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	response.Message(w, http.StatusAccepted, "Control sent, the replay's next state event confirms it")
+}
@@ -1,21 +1,33 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
+	"sync"
 	"time"
 
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/codec"
 	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/ingest"
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/metrics"
+	"GOLANG_SERVER/components/replay"
+	"GOLANG_SERVER/components/response"
 	schema "GOLANG_SERVER/components/schema"
 
 	"github.com/gorilla/websocket"
 )
 
+var logger = logging.For("ws")
+
 // Handle WebSocket connections
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true }, // Allow all connections
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		response.Status(w, status, reason.Error())
+	},
 }
 
 // Store all connected clients
@@ -24,68 +36,153 @@ var clients = make(map[*websocket.Conn]bool)
 // Store all connected clients for storing data
 var clientsStore = make(map[*websocket.Conn]bool)
 
-var DeviceAdd = "test"
+// * protects clients, each connection registers and unregisters itself
+var clientsMutex sync.Mutex
 
 // Handle a WebSocket connection
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Upgrade the connection to a WebSocket connection
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Error upgrading connection to WebSocket:", err)
+		logger.WarnContext(r.Context(), "Error upgrading connection to WebSocket", "error", err)
 		return
 	}
-
-	// Register the client
-	clients[conn] = true
+	defer conn.Close()
 
 	// * get message from client
 	_, message, err := conn.ReadMessage()
 	if err != nil {
-		log.Println("Error reading message from client:", err)
+		logger.WarnContext(r.Context(), "Error reading message from client", "error", err)
 		return
 	}
 
-	var req schema.GyroData
+	var req struct {
+		schema.GyroData
+		// * {"Replay": {...}} plays stored readings instead of watching a device
+		Replay *replay.Request `json:"Replay"`
+	}
 	if err := json.Unmarshal(message, &req); err != nil {
-		log.Println("Error unmarshaling message:", err)
+		logger.WarnContext(r.Context(), "Error unmarshaling message", "error", err)
 		return
 	}
-	// ! Not add checking data yet : waiting for next time.
-	fmt.Println("Message from client:", string(req.DeviceAddress))
+	organization := auth.Organization(r)
+	if req.Replay != nil {
+		replayTo(r, conn, organization, *req.Replay)
+		return
+	}
+	logger.DebugContext(r.Context(), "Client watching device", "device", req.DeviceAddress)
 
-	// * change device address
-	DeviceAdd = req.DeviceAddress
+	// * only devices of the user's organization can be watched
+	if !deviceVisible(r.Context(), organization, req.DeviceAddress) {
+		conn.WriteJSON(response.Envelope{Error: response.ErrorBody{Code: response.CodeNotFound, Message: "Device not found"}})
+		return
+	}
 
-	// Log the number of clients
-	fmt.Println("Number of clients:", len(clients))
+	// Register the client
+	clientsMutex.Lock()
+	clients[conn] = true
+	logger.InfoContext(r.Context(), "Client connected", "clients", len(clients))
+	clientsMutex.Unlock()
+	defer metrics.ClientConnected("ws")()
 
-	// Wait for a message from the client
+	defer func() {
+		clientsMutex.Lock()
+		delete(clients, conn)
+		clientsMutex.Unlock()
+	}()
+
+	// Send the latest data of the requested device every second
 	for {
-		// Send the message to all clients
-		for client := range clients {
-			// * get data from database
-			data, err := db.GetGyroDataByDeviceAddressLatest(DeviceAdd)
+		// * get data from database
+		data, err := db.GetGyroDataByDeviceAddressLatest(organization, req.DeviceAddress)
+		if err == nil {
+			jsonData, err := json.Marshal(data)
 			if err != nil {
-				// log.Println("Error getting data from database:", err)
-				continue
+				logger.ErrorContext(r.Context(), "Error marshaling data to JSON", "error", err)
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+				metrics.Dropped("ws")
+				logger.InfoContext(r.Context(), "Closing client connection", "error", err)
+				return
 			}
+		}
 
-			jsonData, err := json.Marshal(data)
+		// * delay 1 second
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// replayTo plays stored readings to a client as {"Event": "reading", "Data": GyroData} messages, with
+// {"Event": "replay", "Data": State} whenever the replay changes. Controls are read while it plays.
+func replayTo(r *http.Request, conn *websocket.Conn, organization string, request replay.Request) {
+	// * the reader of controls and the player both answer, a connection takes one writer at a time
+	var writeMutex sync.Mutex
+	write := func(message any) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		return conn.WriteJSON(message)
+	}
+	fail := func(code string, message string) error {
+		return write(response.Envelope{Error: response.ErrorBody{Code: code, Message: message}})
+	}
+
+	for _, device := range request.Devices {
+		if !deviceVisible(r.Context(), organization, device) {
+			fail(response.CodeNotFound, "Device not found: "+device)
+			return
+		}
+	}
+	player, err := replay.New(organization, request)
+	if err != nil {
+		fail(response.CodeBadRequest, err.Error())
+		return
+	}
+	defer metrics.ClientConnected("replay")()
+	logger.InfoContext(r.Context(), "Replay started", "devices", request.Devices, "from", request.From, "to", request.To, "speed", request.Speed)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		// * the client closing the connection ends the replay
+		defer cancel()
+		for {
+			_, message, err := conn.ReadMessage()
 			if err != nil {
-				log.Println("Error marshaling data to JSON:", err)
+				return
+			}
+			var control replay.Control
+			if err := json.Unmarshal(message, &control); err != nil {
+				fail(response.CodeBadRequest, "Invalid control: "+err.Error())
 				continue
 			}
-			if err := client.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-				log.Println("Error writing message to client:", err)
-				log.Println("Closing client connection...")
-				client.Close()
-				delete(clients, client)
+			if err := player.Control(control); err != nil {
+				fail(response.CodeBadRequest, err.Error())
 			}
-
-			// * delay 1 second
-			time.Sleep(1 * time.Second)
 		}
+	}()
+
+	err = player.Play(ctx, func(event string, data any) error {
+		return write(replay.Message{Event: event, Data: data})
+	})
+	if err != nil && ctx.Err() == nil {
+		logger.WarnContext(r.Context(), "Replay stopped", "error", err)
+		fail(response.CodeInternal, "Replay stopped")
+		return
 	}
+	writeMutex.Lock()
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "replay ended"))
+	writeMutex.Unlock()
+}
+
+// deviceVisible reports whether an organization may watch a device, see db.DeviceVisible
+func deviceVisible(ctx context.Context, organization string, deviceAddress string) bool {
+	visible, err := db.DeviceVisible(organization, deviceAddress)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error looking up device", "device", deviceAddress, "error", err)
+		return false
+	}
+	return visible
 }
 
 // Handle a WebSocket connection for storing data
@@ -93,39 +190,41 @@ func HandleStoreWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Upgrade the connection to a WebSocket connection
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Error upgrading connection to WebSocket:", err)
+		logger.WarnContext(r.Context(), "Error upgrading connection to WebSocket", "error", err)
 		return
 	}
 
 	// * disconnect client if there is already a client connected
 	if len(clientsStore) > 0 {
-		log.Println("Client already connected!")
+		logger.WarnContext(r.Context(), "Store client already connected, refusing another one")
 		conn.Close()
 		return
 	}
 
 	// Register the client
 	clientsStore[conn] = true
+	defer metrics.ClientConnected("storews")()
 
 	if len(clientsStore) == 1 {
 		// Wait for a message from the client
 		for {
 			// Read the message from the client
-			_, message, err := conn.ReadMessage()
+			messageType, message, err := conn.ReadMessage()
 			if err != nil {
-				log.Println("Disconnected from store client")
+				logger.InfoContext(r.Context(), "Disconnected from store client")
 				delete(clientsStore, conn)
 				break
 			}
 
-			// Store the data in the database
-			var data schema.GyroData
-			if err := json.Unmarshal(message, &data); err != nil {
-				log.Println("Error unmarshaling message:", err)
-				continue
+			// * text frames carry JSON, binary frames CBOR or the packed layout
+			format := codec.FormatJSON
+			if messageType == websocket.BinaryMessage {
+				format = codec.Sniff(message)
 			}
-			if _, err := db.StoreGyroData(data); err != nil {
-				log.Println("Error storing data in database:", err)
+
+			// Validate and store the data in the database
+			if _, err := ingest.Store("", ingest.SourceWS, format, message); err != nil {
+				logger.WarnContext(r.Context(), "Error storing message", "error", err)
 				continue
 			}
 
@@ -134,8 +233,8 @@ func HandleStoreWebSocket(w http.ResponseWriter, r *http.Request) {
 			// Send the message to all clients
 			for client := range clientsStore {
 				if err := client.WriteMessage(websocket.TextMessage, resmes); err != nil {
-					log.Println("Error writing message to client:", err)
-					log.Println("Closing client connection...")
+					metrics.Dropped("storews")
+					logger.InfoContext(r.Context(), "Closing client connection", "error", err)
 					client.Close()
 					delete(clientsStore, client)
 				}
@@ -143,47 +242,3 @@ func HandleStoreWebSocket(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
-
-// Handle a WebSocket connection for getting data
-func handleWebSocketGetData(w http.ResponseWriter, r *http.Request) {
-	// Upgrade the connection to a WebSocket connection
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("Error upgrading connection to WebSocket:", err)
-		return
-	}
-
-	// Register the client
-	clients[conn] = true
-
-	// Log the number of clients
-	fmt.Println("Number of clients:", len(clients))
-
-	// Wait for a message from the client
-	for {
-		// Send the message to all clients
-		for client := range clients {
-			// * get data from database
-			data, err := db.GetGyroDataByDeviceAddressLatest(DeviceAdd)
-			if err != nil {
-				// log.Println("Error getting data from database:", err)
-				continue
-			}
-
-			jsonData, err := json.Marshal(data)
-			if err != nil {
-				log.Println("Error marshaling data to JSON:", err)
-				continue
-			}
-			if err := client.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-				log.Println("Error writing message to client:", err)
-				log.Println("Closing client connection...")
-				client.Close()
-				delete(clients, client)
-			}
-
-			// * delay 1 second
-			time.Sleep(1 * time.Second)
-		}
-	}
-}
@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/response"
+)
+
+var logger = logging.For("ratelimit")
+
+// Failed logins before every further attempt is delayed, and before the account is locked
+const (
+	delayAfterFailures = 3
+	lockAfterFailures  = 10
+	maxDelay           = 30 * time.Second
+	lockDuration       = 15 * time.Minute
+	failureMemory      = time.Hour
+)
+
+var store Store = NewMemoryStore()
+
+// Whether X-Forwarded-For is trusted (TRUST_PROXY), only behind a reverse proxy
+var trustProxy bool
+
+// SetTrustProxy sets whether the client address is taken from X-Forwarded-For
+func SetTrustProxy(trust bool) {
+	trustProxy = trust
+}
+
+// SetStore replaces the store the limits are kept in
+func SetStore(newStore Store) {
+	store = newStore
+}
+
+// Allow counts a request against key and reports whether it stays within limit requests per window.
+// When it doesn't, the returned duration is how long until the window resets.
+func Allow(key string, limit int, window time.Duration) (bool, time.Duration) {
+	now := time.Now()
+	entry, err := store.Update(key, window, func(entry *Entry) {
+		if now.Sub(entry.WindowStart) >= window {
+			entry.WindowStart = now
+			entry.Count = 0
+		}
+		entry.Count++
+		entry.Last = now
+	})
+	if err != nil {
+		// * a broken store must not lock everybody out
+		logger.Error("Error updating rate limit", "error", err)
+		return true, 0
+	}
+	if entry.Count > limit {
+		return false, entry.WindowStart.Add(window).Sub(now)
+	}
+	return true, 0
+}
+
+// LoginWait returns how long the account has to wait before the next login attempt:
+// the remaining lockout, or the progressive delay after the last failures. It only reads,
+// addresses without failures leave nothing in the store.
+func LoginWait(email string) time.Duration {
+	entry, ok, err := store.Get(loginKey(email))
+	if err != nil {
+		logger.Error("Error reading login failures", "error", err)
+		return 0
+	}
+	if !ok {
+		return 0
+	}
+	now := time.Now()
+	if now.Before(entry.LockedUntil) {
+		return entry.LockedUntil.Sub(now)
+	}
+	if next := entry.Last.Add(failureDelay(entry.Count)); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// LoginFailed records a failed login and reports whether the account just got locked
+func LoginFailed(email string) bool {
+	locked := false
+	now := time.Now()
+	_, err := store.Update(loginKey(email), failureMemory, func(entry *Entry) {
+		entry.Count++
+		entry.Last = now
+		if entry.Count >= lockAfterFailures {
+			entry.LockedUntil = now.Add(lockDuration)
+			entry.Count = 0
+			locked = true
+		}
+	})
+	if err != nil {
+		logger.Error("Error recording login failure", "error", err)
+	}
+	return locked
+}
+
+// LoginSucceeded forgets the failures of an account
+func LoginSucceeded(email string) {
+	if err := store.Delete(loginKey(email)); err != nil {
+		logger.Error("Error resetting login failures", "error", err)
+	}
+}
+
+// failureDelay doubles with every failure past delayAfterFailures: 1s, 2s, 4s ... up to maxDelay
+func failureDelay(failures int) time.Duration {
+	if failures < delayAfterFailures {
+		return 0
+	}
+	delay := time.Duration(math.Pow(2, float64(failures-delayAfterFailures))) * time.Second
+	return min(delay, maxDelay)
+}
+
+func loginKey(email string) string {
+	return "login-account:" + strings.ToLower(email)
+}
+
+// ClientIP returns the address of the client. X-Forwarded-For is only trusted
+// when TRUST_PROXY is set, i.e. when the server runs behind a reverse proxy.
+func ClientIP(r *http.Request) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// TooManyRequests answers a limited request with 429 and a Retry-After header
+func TooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	response.Error(w, http.StatusTooManyRequests, response.CodeRateLimited, "Too many requests, try again later")
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginWait(t *testing.T) {
+	memory := &MemoryStore{entries: make(map[string]memoryEntry)}
+	SetStore(memory)
+	t.Cleanup(func() { SetStore(NewMemoryStore()) })
+
+	// * checking addresses nobody failed to log in as stores nothing
+	for _, email := range []string{"made-up-1@example.com", "made-up-2@example.com"} {
+		if wait := LoginWait(email); wait != 0 {
+			t.Errorf("LoginWait(%s) = %v, want 0", email, wait)
+		}
+	}
+	if len(memory.entries) != 0 {
+		t.Fatalf("store has %d entries after checks only, want none", len(memory.entries))
+	}
+
+	for range delayAfterFailures {
+		LoginFailed("ada@example.com")
+	}
+	before := memory.entries[loginKey("ada@example.com")].expires
+	wait := LoginWait("Ada@example.com")
+	if wait <= 0 || wait > time.Second {
+		t.Fatalf("wait after %d failures = %v, want up to 1s", delayAfterFailures, wait)
+	}
+	if memory.entries[loginKey("ada@example.com")].expires != before {
+		t.Error("checking the wait kept the failures longer")
+	}
+
+	LoginSucceeded("ada@example.com")
+	if wait := LoginWait("ada@example.com"); wait != 0 {
+		t.Errorf("wait after a successful login = %v, want 0", wait)
+	}
+}
@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Entry is the state kept per key: a counter for the current window and an optional lockout
+type Entry struct {
+	Count       int
+	WindowStart time.Time
+	Last        time.Time
+	LockedUntil time.Time
+}
+
+// Store keeps the entries. The in-memory store is enough for a single server; a shared store
+// (e.g. Redis or MongoDB) can be plugged in with SetStore when running several instances.
+type Store interface {
+	// Get returns the entry of key, ok is false if there is none
+	Get(key string) (entry Entry, ok bool, err error)
+	// Update atomically applies update to the entry of key (zero if missing) and keeps it for ttl
+	Update(key string, ttl time.Duration, update func(entry *Entry)) (Entry, error)
+	Delete(key string) error
+}
+
+type memoryEntry struct {
+	entry   Entry
+	expires time.Time
+}
+
+// MemoryStore is the default Store, entries live in the server process
+type MemoryStore struct {
+	entries map[string]memoryEntry
+	mutex   sync.Mutex
+}
+
+// NewMemoryStore creates an in-memory store and starts removing expired entries every minute
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{entries: make(map[string]memoryEntry)}
+	go func() {
+		for range time.Tick(time.Minute) {
+			store.sweep()
+		}
+	}()
+	return store
+}
+
+func (s *MemoryStore) Get(key string) (Entry, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, ok := s.entries[key]
+	if !ok || time.Now().After(current.expires) {
+		return Entry{}, false, nil
+	}
+	return current.entry, true, nil
+}
+
+func (s *MemoryStore) Update(key string, ttl time.Duration, update func(entry *Entry)) (Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, ok := s.entries[key]
+	if !ok || time.Now().After(current.expires) {
+		current = memoryEntry{}
+	}
+	update(&current.entry)
+	current.expires = time.Now().Add(ttl)
+	s.entries[key] = current
+	return current.entry, nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) sweep() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
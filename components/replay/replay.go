@@ -0,0 +1,299 @@
+package replay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"GOLANG_SERVER/components/db"
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// Events a replay sends: stored readings, and its state whenever it changes
+const (
+	EventReading = "reading"
+	EventState   = "replay"
+)
+
+// States of a replay
+const (
+	StatePlaying = "playing"
+	StatePaused  = "paused"
+	StateEnded   = "ended"
+)
+
+// Controls of a running replay
+const (
+	ControlPause  = "pause"
+	ControlResume = "resume"
+	ControlSeek   = "seek"
+	ControlSpeed  = "speed"
+)
+
+// Fastest and slowest a replay can be played; slower, a reading would be hours or days apart
+const (
+	minSpeed = 0.01
+	maxSpeed = 1000
+)
+
+// How many readings are read from the database at once; no cursor stays open while a replay is paused
+const pageSize = 1000
+
+// errSeek ends the page being played, the replay goes on from the seek position
+var errSeek = errors.New("seek")
+
+// Request selects the readings a replay plays. To is now if not set, Speed 1 (the original pacing).
+type Request struct {
+	Devices []string  `json:"Devices"`
+	From    time.Time `json:"From"`
+	To      time.Time `json:"To"`
+	Speed   float64   `json:"Speed"`
+}
+
+// Control changes a running replay: pause, resume, seek to Time, or play at Speed
+type Control struct {
+	Control string    `json:"Control"`
+	Time    time.Time `json:"Time"`
+	Speed   float64   `json:"Speed"`
+}
+
+// State is where a replay is; ID names it for controls sent on another connection
+type State struct {
+	ID       string    `json:"ID"`
+	State    string    `json:"State"`
+	Position time.Time `json:"Position"`
+	Speed    float64   `json:"Speed"`
+}
+
+// Message is an event of a replay as sent over a WebSocket
+type Message struct {
+	Event string `json:"Event"`
+	Data  any    `json:"Data"`
+}
+
+// Player plays stored readings of an organization at their original pacing, or faster
+type Player struct {
+	ID           string
+	organization string
+	request      Request
+	controls     chan Control
+
+	// * the clock, only used by Play: the reading of time media is due at wall, readings after it speed times faster
+	media  int64
+	wall   time.Time
+	speed  float64
+	paused bool
+	send   func(event string, data any) error
+}
+
+// * running players by ID, for controls sent on another connection than the replay (SSE)
+var players = make(map[string]*Player)
+var playersMutex sync.Mutex
+
+// New checks a replay request and prepares its player
+func New(organization string, request Request) (*Player, error) {
+	if request.To.IsZero() {
+		request.To = time.Now()
+	}
+	if request.Speed == 0 {
+		request.Speed = 1
+	}
+	switch {
+	case request.From.IsZero():
+		return nil, errors.New("From is required")
+	case !request.From.Before(request.To):
+		return nil, errors.New("From must be before To")
+	case request.Speed < minSpeed || request.Speed > maxSpeed:
+		return nil, fmt.Errorf("Speed must be between %g and %d", minSpeed, maxSpeed)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Player{
+		ID:           hex.EncodeToString(id),
+		organization: organization,
+		request:      request,
+		controls:     make(chan Control, 16),
+		speed:        request.Speed,
+	}, nil
+}
+
+// Find returns the running player of an organization with an ID
+func Find(organization string, id string) (*Player, bool) {
+	playersMutex.Lock()
+	defer playersMutex.Unlock()
+	player, ok := players[id]
+	if !ok || player.organization != organization {
+		return nil, false
+	}
+	return player, true
+}
+
+// Control hands a control to the running replay, after checking it
+func (p *Player) Control(control Control) error {
+	switch control.Control {
+	case ControlPause, ControlResume:
+	case ControlSeek:
+		if control.Time.Before(p.request.From) || control.Time.After(p.request.To) {
+			return errors.New("Time must be between From and To of the replay")
+		}
+	case ControlSpeed:
+		if control.Speed < minSpeed || control.Speed > maxSpeed {
+			return fmt.Errorf("Speed must be between %g and %d", minSpeed, maxSpeed)
+		}
+	default:
+		return fmt.Errorf("unknown control %q, use pause, resume, seek or speed", control.Control)
+	}
+
+	select {
+	case p.controls <- control:
+		return nil
+	default:
+		return errors.New("too many controls at once, try again")
+	}
+}
+
+// Play sends the readings until To is reached or ctx is done, applying controls as they come.
+// send gets EventReading with a GyroData and EventState with a State.
+func (p *Player) Play(ctx context.Context, send func(event string, data any) error) error {
+	playersMutex.Lock()
+	players[p.ID] = p
+	playersMutex.Unlock()
+	defer func() {
+		playersMutex.Lock()
+		delete(players, p.ID)
+		playersMutex.Unlock()
+	}()
+
+	p.send = send
+	position := p.request.From.UnixMilli()
+	// * readings of the position's time sent already, pages start at a time and skip those
+	skip := 0
+	p.media, p.wall = position, time.Now()
+	if err := p.state(StatePlaying); err != nil {
+		return err
+	}
+
+	for {
+		filter := db.ReadingFilter{Devices: p.request.Devices, From: position, To: p.request.To.UnixMilli(), Limit: int64(pageSize + skip)}
+		var page []schema.GyroData
+		if err := db.EachGyroData(ctx, p.organization, filter, func(data schema.GyroData) error {
+			page = append(page, data)
+			return nil
+		}); err != nil {
+			return err
+		}
+		complete := len(page) < pageSize+skip
+		page = page[min(skip, len(page)):]
+
+		seeked := false
+		for _, data := range page {
+			err := p.wait(ctx, data.TimeStamp)
+			if errors.Is(err, errSeek) {
+				position, skip, seeked = p.media, 0, true
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := send(EventReading, data); err != nil {
+				return err
+			}
+			if data.TimeStamp == position {
+				skip++
+			} else {
+				position, skip = data.TimeStamp, 1
+			}
+		}
+		if !seeked && complete {
+			p.media, p.paused = p.request.To.UnixMilli(), true
+			return p.state(StateEnded)
+		}
+	}
+}
+
+// wait returns once the reading of a time is due, applying the controls that come meanwhile
+func (p *Player) wait(ctx context.Context, at int64) error {
+	for {
+		var due <-chan time.Time
+		if !p.paused {
+			delay := p.wall.Add(time.Duration(float64(at-p.media) / p.speed * float64(time.Millisecond))).Sub(time.Now())
+			if delay <= 0 {
+				return nil
+			}
+			due = time.After(delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-due:
+			return nil
+		case control := <-p.controls:
+			if err := p.apply(control); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// apply changes the clock; a seek returns errSeek
+func (p *Player) apply(control Control) error {
+	now := time.Now()
+	// * re-anchor the clock at the current position, the new pacing counts from now
+	p.media, p.wall = p.position(now), now
+
+	state := StatePlaying
+	switch control.Control {
+	case ControlPause:
+		p.paused = true
+	case ControlResume:
+		p.paused = false
+	case ControlSpeed:
+		p.speed = control.Speed
+	case ControlSeek:
+		p.media = control.Time.UnixMilli()
+	}
+	if p.paused {
+		state = StatePaused
+	}
+	if err := p.state(state); err != nil {
+		return err
+	}
+	if control.Control == ControlSeek {
+		return errSeek
+	}
+	return nil
+}
+
+// position is the replayed time at a wall time
+func (p *Player) position(now time.Time) int64 {
+	if p.paused {
+		return p.media
+	}
+	return p.media + int64(float64(now.Sub(p.wall).Milliseconds())*p.speed)
+}
+
+func (p *Player) state(state string) error {
+	return p.send(EventState, State{
+		ID:       p.ID,
+		State:    state,
+		Position: time.UnixMilli(p.position(time.Now())).UTC(),
+		Speed:    p.speed,
+	})
+}
+
+// ParseTime parses a time given as RFC 3339 or Unix milliseconds
+func ParseTime(value string) (time.Time, error) {
+	if milliseconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(milliseconds), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,45 @@
+package replay
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpeedLimits(t *testing.T) {
+	from := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		speed float64
+		ok    bool
+	}{
+		{0.01, true},
+		{1, true},
+		{maxSpeed, true},
+		{0.001, false},
+		{-1, false},
+		{maxSpeed + 1, false},
+	}
+	for _, test := range tests {
+		_, err := New("acme", Request{From: from, To: from.Add(time.Hour), Speed: test.speed})
+		if test.ok != (err == nil) {
+			t.Errorf("New with speed %g: error = %v", test.speed, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "between 0.01 and 1000") {
+			t.Errorf("New with speed %g: error = %v, want the allowed range", test.speed, err)
+		}
+
+		player, _ := New("acme", Request{From: from, To: from.Add(time.Hour)})
+		if err := player.Control(Control{Control: ControlSpeed, Speed: test.speed}); test.ok != (err == nil) {
+			t.Errorf("speed control %g: error = %v", test.speed, err)
+		}
+	}
+
+	// * speed 0 asks for the original pacing
+	player, err := New("acme", Request{From: from, To: from.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if player.speed != 1 {
+		t.Errorf("New without speed: speed %g, want 1", player.speed)
+	}
+}
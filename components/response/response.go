@@ -0,0 +1,125 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/ingest"
+	"GOLANG_SERVER/components/logging"
+)
+
+// Error codes are part of the API: clients switch on them, so they never change meaning
+const (
+	CodeBadRequest         = "bad_request"
+	CodeValidation         = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeAccountDisabled    = "account_disabled"
+	CodeTwoFactorRequired  = "two_factor_required"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeRateLimited        = "rate_limited"
+	CodeInternal           = "internal_error"
+)
+
+var logger = logging.For("response")
+
+// ErrorBody is the error of every failed request: {"error": {"code", "message", "details"}}
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Envelope wraps ErrorBody, also sent over WebSockets
+type Envelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+// JSON writes body as the JSON response with the given status
+func JSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		// * the status is sent already, all that is left is to log it
+		logger.Error("Error encoding response", "error", err)
+	}
+}
+
+// Message writes {"message": message}
+func Message(w http.ResponseWriter, status int, message string) {
+	JSON(w, status, map[string]string{"message": message})
+}
+
+// Error writes an error response
+func Error(w http.ResponseWriter, status int, code string, message string) {
+	ErrorDetails(w, status, code, message, nil)
+}
+
+// ErrorDetails writes an error response with details, e.g. the problems found in a payload
+func ErrorDetails(w http.ResponseWriter, status int, code string, message string, details any) {
+	// * Content-Length may belong to a body the handler meant to send
+	w.Header().Del("Content-Length")
+	JSON(w, status, Envelope{Error: ErrorBody{Code: code, Message: message, Details: details}})
+}
+
+// Status writes an error response with the generic code of the status
+func Status(w http.ResponseWriter, status int, message string) {
+	Error(w, status, codeFor(status), message)
+}
+
+// BadRequest answers 400 for a request that can't be understood, e.g. malformed JSON
+func BadRequest(w http.ResponseWriter, err error) {
+	Error(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+}
+
+// MethodNotAllowed answers 405
+func MethodNotAllowed(w http.ResponseWriter) {
+	Error(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+}
+
+// Err answers with the status an error of the storage or ingestion layers stands for: 404 for
+// something that doesn't exist, 409 for something that already does, 422 for a rejected reading.
+// Anything else is a 500 whose details are logged but not sent to the client.
+func Err(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		Error(w, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, db.ErrExists):
+		Error(w, http.StatusConflict, CodeConflict, err.Error())
+	case errors.Is(err, ingest.ErrRejected):
+		Error(w, http.StatusUnprocessableEntity, CodeValidation, err.Error())
+	default:
+		logger.ErrorContext(r.Context(), "Internal error", "path", r.URL.Path, "error", err)
+		Error(w, http.StatusInternalServerError, CodeInternal, "Internal server error")
+	}
+}
+
+func codeFor(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodeValidation
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
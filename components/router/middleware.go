@@ -0,0 +1,162 @@
+package router
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/response"
+)
+
+var logger = logging.For("router")
+
+// Middleware wraps a handler
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps handler in the middlewares, the first one is the outermost
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Recover turns a panicking handler into a 500 instead of a dropped connection
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			logger.ErrorContext(r.Context(), "Handler panicked", "path", r.URL.Path, "panic", err, "stack", string(debug.Stack()))
+			response.Error(w, http.StatusInternalServerError, response.CodeInternal, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CORS lets browser apps of the given origins call the API ("*" for any) and answers their
+// preflight requests. Without origins no CORS headers are sent, only same-origin pages can call.
+func CORS(origins []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(origins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || (!slices.Contains(origins, "*") && !slices.Contains(origins, origin)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Deprecation, Link")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID")
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// Compress gzips responses for clients accepting it. WebSocket upgrades and responses the
+// handler already encoded (e.g. /metrics) are left alone.
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		writer := &gzipResponseWriter{ResponseWriter: w}
+		defer writer.Close()
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// gzipResponseWriter decides on the first write whether to compress
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gzip        *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	header := g.Header()
+	if header.Get("Content-Encoding") == "" && status != http.StatusNoContent && status != http.StatusNotModified {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		g.gzip = gzipWriters.Get().(*gzip.Writer)
+		g.gzip.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gzip == nil {
+		return g.ResponseWriter.Write(data)
+	}
+	return g.gzip.Write(data)
+}
+
+// Flush sends what was compressed so far, for streamed responses
+func (g *gzipResponseWriter) Flush() {
+	if g.gzip != nil {
+		g.gzip.Flush()
+	}
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := g.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	return hijacker.Hijack()
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipResponseWriter) Close() {
+	if g.gzip == nil {
+		return
+	}
+	g.gzip.Close()
+	gzipWriters.Put(g.gzip)
+	g.gzip = nil
+}
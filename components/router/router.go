@@ -0,0 +1,129 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"GOLANG_SERVER/components/response"
+)
+
+// APIPrefix is the root of the versioned API
+const APIPrefix = "/api/v1"
+
+// Route is a registered endpoint. Deprecated routes are the paths from before /api/v1.
+type Route struct {
+	Method     string
+	Path       string
+	Deprecated bool
+	Successor  string
+}
+
+// Router dispatches on method and path patterns ("GET /data/{device}"). A path registered
+// for other methods answers 405 with an Allow header.
+type Router struct {
+	mux    *http.ServeMux
+	routes []Route
+}
+
+func New() *Router {
+	return &Router{mux: http.NewServeMux()}
+}
+
+// Handle registers a handler for "METHOD /path" as it is, for endpoints outside the
+// versioned API such as /healthz
+func (rt *Router) Handle(pattern string, handler http.Handler) {
+	method, path := split(pattern)
+	rt.mux.Handle(method+" "+path, handler)
+	rt.routes = append(rt.routes, Route{Method: method, Path: path})
+}
+
+// API registers a handler for "METHOD /path" under /api/v1, and at every alias: the old
+// paths, which keep working but announce their successor in Deprecation and Link headers
+func (rt *Router) API(pattern string, handler http.HandlerFunc, aliases ...string) {
+	method, path := split(pattern)
+	path = APIPrefix + path
+	rt.mux.Handle(method+" "+path, handler)
+	rt.routes = append(rt.routes, Route{Method: method, Path: path})
+
+	for _, alias := range aliases {
+		rt.mux.Handle(method+" "+alias, deprecated(path, handler))
+		rt.routes = append(rt.routes, Route{Method: method, Path: alias, Deprecated: true, Successor: path})
+	}
+}
+
+// Routes returns every registered route in registration order
+func (rt *Router) Routes() []Route {
+	return rt.routes
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// * no route: the mux answers 404, 405 or redirects to the canonical path
+	if _, pattern := rt.mux.Handler(r); pattern == "" {
+		rt.mux.ServeHTTP(&muxErrorWriter{ResponseWriter: w}, r)
+		return
+	}
+	rt.mux.ServeHTTP(w, r)
+}
+
+// muxErrorWriter replaces the plain text 404 and 405 of the mux with JSON errors, keeping its Allow header
+type muxErrorWriter struct {
+	http.ResponseWriter
+	replaced bool
+}
+
+func (m *muxErrorWriter) WriteHeader(status int) {
+	switch status {
+	case http.StatusNotFound:
+		response.Error(m.ResponseWriter, status, response.CodeNotFound, "Not found")
+		m.replaced = true
+	case http.StatusMethodNotAllowed:
+		response.MethodNotAllowed(m.ResponseWriter)
+		m.replaced = true
+	default:
+		m.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (m *muxErrorWriter) Write(data []byte) (int, error) {
+	if m.replaced {
+		return len(data), nil
+	}
+	return m.ResponseWriter.Write(data)
+}
+
+// split separates "METHOD /path"; every route must name its method
+func split(pattern string) (string, string) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || method == "" || !strings.HasPrefix(path, "/") {
+		panic(fmt.Sprintf("router: pattern %q must be \"METHOD /path\"", pattern))
+	}
+	return method, path
+}
+
+// deprecated answers like the successor route and points clients at it (RFC 8594, RFC 9745)
+func deprecated(successor string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+fill(successor, r)+`>; rel="successor-version"`)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// fill replaces the wildcards of a path pattern with the values of the request
+func fill(pattern string, r *http.Request) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.Trim(segment, "{}"), "...")
+		if name == "$" {
+			segments[i] = ""
+			continue
+		}
+		segments[i] = url.PathEscape(r.PathValue(name))
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"net/http"
+
+	"GOLANG_SERVER/components/apidocs"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/health"
+	"GOLANG_SERVER/components/protocal/rest"
+	"GOLANG_SERVER/components/protocal/sse"
+	"GOLANG_SERVER/components/protocal/ws"
+	"GOLANG_SERVER/components/router"
+	"GOLANG_SERVER/components/schema"
+	"GOLANG_SERVER/components/user"
+)
+
+// API returns the router of every route the server serves. The apidocs tests check
+// openapi.yaml against it.
+func API() *router.Router {
+	// * every route names its methods, other methods get a 405 with an Allow header.
+	// * The paths from before /api/v1 keep working as deprecated aliases.
+	api := router.New()
+	api.Handle("GET /healthz", http.HandlerFunc(health.HandleHealthz))                    //*DONE Liveness for Docker and Kubernetes
+	api.Handle("GET /readyz", http.HandlerFunc(health.HandleReadyz))                      //*DONE Readiness with a breakdown per dependency
+	api.Handle("GET /api/docs", http.HandlerFunc(apidocs.HandleDocs))                     //*DONE Interactive API documentation
+	api.Handle("GET /api/docs/openapi.json", http.HandlerFunc(apidocs.HandleSpec))        //*DONE OpenAPI document
+	api.Handle("GET /api/docs/swagger-ui/{file}", http.HandlerFunc(apidocs.HandleAssets)) //*DONE Swagger UI files
+
+	// * ingestion endpoints stay open for the sensor gateways, everything else needs a session
+	api.API("GET /{$}", rest.HandleAPI, "/api")
+	api.API("GET /data", auth.Require(schema.RoleViewer, rest.HandleGetAllData), "/data")
+	api.API("POST /store", rest.HandleStore, "/store")
+	api.API("POST /store/bulk", rest.HandleStoreBulk, "/store/bulk")
+	api.API("POST /latest", auth.Require(schema.RoleViewer, rest.HandleGetLatestData), "/latest")
+	api.API("POST /clean", auth.Require(schema.RoleAdmin, rest.HandleCleanData), "/clean")
+	api.API("GET /duplicates", auth.Require(schema.RoleViewer, rest.HandleGetDuplicates), "/duplicates")
+	api.API("GET /export", auth.Require(schema.RoleViewer, rest.HandleExport))                //*DONE Export readings as CSV or XLSX
+	api.API("GET /export/parquet", auth.Require(schema.RoleViewer, rest.HandleExportParquet)) //*DONE Export readings as Parquet files per device and day
+	api.API("POST /import", auth.Require(schema.RoleEngineer, rest.HandleImport))             //*DONE Import historical readings from CSV or NDJSON
+
+	api.API("POST /devices", auth.Require(schema.RoleEngineer, rest.HandleRegisterDevice), "/registerdevice")                                       //*DONE Register device
+	api.API("GET /devices", auth.Require(schema.RoleViewer, rest.HandleGetDeviceAddress), "/deviceaddresses")                                       //*DONE Get device address
+	api.API("GET /devices/{device}", auth.Require(schema.RoleViewer, rest.HandleGetDeviceAddressByDeviceAddress), "/checkdeviceaddresses/{device}") //*DONE Get device address by device address
+	api.API("GET /data/{device}", auth.Require(schema.RoleViewer, rest.HandleGetAllDataByDeviceAddress), "/data/{device}")                          //*DONE Get data use param
+	api.API("POST /register", user.Register, "/register")                                                                                           //*DONE Register user by Enail and Password
+	api.API("POST /login", user.Login, "/login")                                                                                                    //*DONE login user by Email and Password
+	api.API("POST /login/2fa", user.LoginTwoFactor, "/login/2fa")                                                                                   //*DONE Second login step with a TOTP or recovery code
+	api.API("GET /oidc/login", user.SSOLogin, "/oidc/login")                                                                                        //*DONE Start single sign-on at the identity provider
+	api.API("GET /oidc/callback", user.SSOCallback, "/oidc/callback")                                                                               //*DONE Finish single sign-on, provisions the user
+	api.API("POST /logout", user.Logout, "/logout")                                                                                                 //*DONE End the current session
+	api.API("POST /sendotp", user.SendOTP, "/sendotp")                                                                                              //*DONE Send OTP to Email
+	api.API("POST /password/forgot", user.ForgotPassword, "/password/forgot")                                                                       //*DONE Email a password reset link
+	api.API("POST /password/reset", user.ResetPassword, "/password/reset")                                                                          //*DONE Set a new password with a reset token
+	api.API("GET /me", auth.Authenticated(user.Me), "/me")                                                                                          //*DONE Get your own account
+	api.API("PUT /me", auth.Authenticated(user.Me), "/me")                                                                                          //*DONE Update your own account
+	api.API("DELETE /me", auth.Authenticated(user.Me), "/me")                                                                                       //*DONE Delete your own account
+	api.API("POST /me/password", auth.Authenticated(user.ChangePassword), "/me/password")                                                           //*DONE Change your password
+	api.API("POST /me/email", auth.Authenticated(user.ChangeEmail), "/me/email")                                                                    //*DONE Change your email
+	api.API("GET /users", auth.Require(schema.RoleAdmin, user.Users), "/users")                                                                     //*DONE List users
+	api.API("GET /users/{email}", auth.Require(schema.RoleAdmin, user.UserByEmail), "/users/{email}")                                               //*DONE Get a user
+	api.API("DELETE /users/{email}", auth.Require(schema.RoleAdmin, user.UserByEmail), "/users/{email}")                                            //*DONE Delete a user
+	api.API("POST /users/disable", auth.Require(schema.RoleAdmin, user.SetDisabled), "/users/disable")                                              //*DONE Disable or re-enable a user
+	api.API("POST /users/role", auth.Require(schema.RoleAdmin, user.SetRole), "/users/role")                                                        //*DONE Assign a role to a user
+	api.API("POST /users/organization", auth.Require(schema.RoleAdmin, user.SetOrganization), "/users/organization")                                //*DONE Move a user into an organization
+	api.API("POST /users/sso", auth.Require(schema.RoleAdmin, user.AllowSSO))                                                                       //*DONE Let a local account log in through single sign-on
+	api.API("GET /organizations", auth.Require(schema.RoleAdmin, user.Organizations), "/organizations")                                             //*DONE List organizations
+	api.API("POST /organizations", auth.Require(schema.RoleAdmin, user.Organizations), "/organizations")                                            //*DONE Create an organization
+	api.API("POST /2fa/enroll", auth.Authenticated(user.EnrollTwoFactor), "/2fa/enroll")                                                            //*DONE Start TOTP enrollment
+	api.API("POST /2fa/confirm", auth.Authenticated(user.ConfirmTwoFactor), "/2fa/confirm")                                                         //*DONE Confirm TOTP enrollment, get recovery codes
+	api.API("POST /2fa/disable", auth.Authenticated(user.DisableTwoFactor), "/2fa/disable")                                                         //*DONE Turn TOTP off
+	api.API("POST /organizations/2fa", auth.Require(schema.RoleAdmin, user.SetTwoFactorRoles), "/organizations/2fa")                                //*DONE Require TOTP for roles of the organization
+	api.API("GET /audit", auth.Require(schema.RoleAdmin, rest.HandleGetAudit), "/audit")                                                            //*DONE Query or export the audit log
+	api.API("GET /rules", auth.Require(schema.RoleEngineer, rest.HandleRules), "/rules")                                                            //*DONE Get validation rules
+	api.API("PUT /rules", auth.Require(schema.RoleEngineer, rest.HandleRules), "/rules")                                                            //*DONE Edit validation rules
+	api.API("GET /quarantine", auth.Require(schema.RoleEngineer, rest.HandleGetQuarantine), "/quarantine")                                          //*DONE List rejected payloads
+	api.API("POST /quarantine/{id}/replay", auth.Require(schema.RoleEngineer, rest.HandleReplayQuarantine), "/quarantine/replay/{id}")              //*DONE Replay a rejected payload
+
+	// TODO: WebSocket route
+	api.API("GET /ws", auth.Require(schema.RoleViewer, ws.HandleWebSocket), "/ws")                //*DONE Handle WebSocket connection
+	api.API("GET /stream", auth.Require(schema.RoleViewer, sse.HandleStream))                     //*DONE Live readings, alerts and device status as Server-Sent Events
+	api.API("GET /stream/replay", auth.Require(schema.RoleViewer, sse.HandleReplay))              //*DONE Replay stored readings as Server-Sent Events
+	api.API("POST /stream/replay/{id}", auth.Require(schema.RoleViewer, sse.HandleReplayControl)) //*DONE Pause, resume, seek or speed up a replay
+	api.API("GET /storews", ws.HandleStoreWebSocket, "/storews")                                  //*DONE Store data from websocket
+	return api
+}
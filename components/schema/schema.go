@@ -1,5 +1,11 @@
 package schema
 
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
 type GyroStruct struct {
 	Acceleration                   float32 `json:"Acceleration"`
 	VelocityAngular                float32 `json:"VelocityAngular"`
@@ -19,14 +25,116 @@ type GyroData struct {
 	Z               GyroStruct `json:"Z"`
 	Temperature     float32    `json:"Temperature"`
 	ModbusHighSpeed bool       `json:"ModbusHighSpeed"`
+
+	// Optional identifiers set by the gateway so retried messages can be recognised as duplicates.
+	// Sequence counts the messages of one boot of the gateway, named by BootID; it starts over after a
+	// reboot, so it only recognises duplicates together with BootID. It is a pointer because 0 is a sequence.
+	MessageID       string `json:"MessageID,omitempty" bson:",omitempty"`
+	BootID          string `json:"BootID,omitempty" bson:",omitempty"`
+	Sequence        *int64 `json:"Sequence,omitempty" bson:",omitempty"`
+	DeviceTimeStamp int64  `json:"DeviceTimeStamp,omitempty" bson:",omitempty"`
+	// ImportHash recognises imported readings without an identifier by their content, set by the server
+	ImportHash string `json:"-" bson:",omitempty"`
+
+	// Organization owning the device, set by the server from the device registry
+	Organization string `json:"-" bson:",omitempty"`
+	// ID of the stored reading, set when it is stored
+	ID primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+}
+
+// QuarantineRecord is a payload that failed validation, kept with the raw bytes so it can be replayed
+type QuarantineRecord struct {
+	ID           primitive.ObjectID `json:"ID" bson:"_id,omitempty"`
+	Source       string             `json:"Source"`
+	Format       string             `json:"Format,omitempty" bson:",omitempty"`
+	Organization string             `json:"-" bson:",omitempty"`
+	Reason       string             `json:"Reason"`
+	Raw          []byte             `json:"Raw"`
+	DateTime     string             `json:"DateTime"`
+	TimeStamp    int64              `json:"TimeStamp"`
 }
 
+// PasswordRequest confirms a destructive operation with the caller's password, typed twice
 type PasswordRequest struct {
 	Password string `json:"Password"`
 	CFP      string `json:"CFP"`
+	// OperatorPassword is the server's PASSWORD, only for the default organization
+	OperatorPassword string `json:"OperatorPassword,omitempty"`
 }
 
+// Roles a user can have, from most to least privileged
+const (
+	RoleAdmin    = "admin"
+	RoleEngineer = "engineer"
+	RoleViewer   = "viewer"
+)
+
 type User struct {
-	Email    string `json:"Email"`
-	Password string `json:"Password"`
+	Email        string `json:"Email"`
+	Password     string `json:"Password"`
+	Role         string `json:"Role"`
+	Organization string `json:"Organization"`
+
+	// Profile settings the user manages via /me
+	DisplayName   string                  `json:"DisplayName" bson:",omitempty"`
+	Timezone      string                  `json:"Timezone" bson:",omitempty"`
+	Notifications NotificationPreferences `json:"Notifications"`
+
+	// Provider is "oidc" for accounts provisioned by single sign-on, which have no password; Subject is their id at the provider
+	Provider string `json:"Provider" bson:",omitempty"`
+	Subject  string `json:"-" bson:",omitempty"`
+
+	// Disabled accounts can't log in, admins disable instead of deleting to keep the history
+	Disabled bool `json:"Disabled" bson:",omitempty"`
+
+	// TOTP two-factor authentication, secrets never leave the server
+	TOTPEnabled       bool     `json:"TOTPEnabled"`
+	TOTPSecret        string   `json:"-" bson:",omitempty"`
+	TOTPPendingSecret string   `json:"-" bson:",omitempty"`
+	TOTPLastStep      int64    `json:"-" bson:",omitempty"`
+	RecoveryCodes     []string `json:"-" bson:",omitempty"`
+}
+
+// NotificationPreferences are the emails a user wants to receive besides the account emails (OTP, password reset)
+type NotificationPreferences struct {
+	DeviceAlerts bool `json:"DeviceAlerts"`
+	WeeklyReport bool `json:"WeeklyReport"`
+}
+
+// ProviderOIDC marks accounts that log in through the OpenID Connect identity provider
+const ProviderOIDC = "oidc"
+
+// DefaultOrganization is the tenant of the server operator; data from before organizations existed is moved into it
+const DefaultOrganization = "default"
+
+// Organization is a tenant owning users and devices. Its gateways publish on "<MQTTPrefix>/sample".
+type Organization struct {
+	Slug       string `json:"Slug"`
+	Name       string `json:"Name"`
+	MQTTPrefix string `json:"MQTTPrefix"`
+
+	// Roles whose members must use two-factor authentication
+	TwoFactorRoles []string `json:"TwoFactorRoles"`
+}
+
+// Session is a login session, only the SHA-256 hash of the bearer token is stored
+type Session struct {
+	TokenHash string    `json:"-"`
+	Email     string    `json:"Email"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+
+	// Challenge marks the short-lived token between the password and the TOTP login step
+	Challenge bool `json:"Challenge" bson:",omitempty"`
+}
+
+// AuditEntry records a security-relevant action: who did what to which target, and how it ended
+type AuditEntry struct {
+	Actor        string `json:"Actor"`
+	IP           string `json:"IP"`
+	Action       string `json:"Action"`
+	Target       string `json:"Target"`
+	Outcome      string `json:"Outcome"`
+	Organization string `json:"Organization,omitempty" bson:",omitempty"`
+	DateTime     string `json:"DateTime"`
+	TimeStamp    int64  `json:"TimeStamp"`
 }
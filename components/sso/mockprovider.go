@@ -0,0 +1,243 @@
+package sso
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"GOLANG_SERVER/components/config"
+)
+
+// MockProvider is a minimal OpenID Connect provider that logs everybody in as Identity without asking.
+// It lets single sign-on be exercised without a real identity provider; set OIDC_MOCK_PROVIDER to a
+// listen address to start it and point OIDC_ISSUER at it. It checks PKCE like a real provider would.
+type MockProvider struct {
+	address  string
+	listener net.Listener
+	key      *rsa.PrivateKey
+
+	// Identity is who logs in
+	Identity Identity
+	// Claims replace those of the ID tokens, e.g. a wrong nonce to test that a bad token is refused
+	Claims map[string]any
+
+	codes map[string]mockCode
+	mutex sync.Mutex
+}
+
+type mockCode struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	identity    Identity
+}
+
+// NewMockProvider creates a provider listening on address, e.g. "127.0.0.1:9096"
+func NewMockProvider(address string, identity Identity) (*MockProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &MockProvider{address: address, key: key, Identity: identity, codes: make(map[string]mockCode)}, nil
+}
+
+// StartMockProvider starts the mock provider of the configuration, logging everybody in as
+// MockEmail with the comma-separated groups of MockGroups
+func StartMockProvider(oidcConfig config.OIDC) (*MockProvider, error) {
+	identity := Identity{Subject: oidcConfig.MockEmail, Email: oidcConfig.MockEmail, Name: "Mock User"}
+	for _, group := range strings.Split(oidcConfig.MockGroups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			identity.Groups = append(identity.Groups, group)
+		}
+	}
+
+	provider, err := NewMockProvider(oidcConfig.MockProvider, identity)
+	if err != nil {
+		return nil, err
+	}
+	return provider, provider.Start()
+}
+
+// Start listens and serves the provider in the background
+func (p *MockProvider) Start() error {
+	listener, err := net.Listen("tcp", p.address)
+	if err != nil {
+		return err
+	}
+	p.listener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("/jwks", p.handleKeys)
+	mux.HandleFunc("/authorize", p.handleAuthorize)
+	mux.HandleFunc("/token", p.handleToken)
+	go http.Serve(listener, mux)
+
+	logger.Warn("Mock OIDC provider listening, development only", "issuer", p.Issuer())
+	return nil
+}
+
+// Issuer returns the issuer URL to set as OIDC_ISSUER
+func (p *MockProvider) Issuer() string {
+	return "http://" + p.listener.Addr().String()
+}
+
+// Close stops the provider
+func (p *MockProvider) Close() error {
+	return p.listener.Close()
+}
+
+func (p *MockProvider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	issuer := p.Issuer()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *MockProvider) handleKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"alg": "RS256",
+		"use": "sig",
+		"kid": "mock",
+		"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+// handleAuthorize approves every request and redirects back with a code
+func (p *MockProvider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "code flow with S256 PKCE required", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Host == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomCode()
+	p.mutex.Lock()
+	p.codes[code] = mockCode{
+		clientID:    query.Get("client_id"),
+		redirectURI: query.Get("redirect_uri"),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		identity:    p.Identity,
+	}
+	p.mutex.Unlock()
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// handleToken exchanges a code for a signed ID token after checking the PKCE verifier
+func (p *MockProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+	}
+
+	p.mutex.Lock()
+	code, found := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mutex.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !found || code.clientID != clientID || code.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            p.Issuer(),
+		"sub":            code.identity.Subject,
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          code.nonce,
+		"email":          code.identity.Email,
+		"email_verified": true,
+		"name":           code.identity.Name,
+		"groups":         code.identity.Groups,
+	}
+	p.mutex.Lock()
+	for name, value := range p.Claims {
+		claims[name] = value
+	}
+	p.mutex.Unlock()
+	idToken, err := p.sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": randomCode(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// sign returns claims as an RS256 JSON Web Token
+func (p *MockProvider) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "mock"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func randomCode() string {
+	code, _ := randomString()
+	return code
+}
@@ -0,0 +1,95 @@
+package sso
+
+import (
+	"errors"
+	"strings"
+
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/schema"
+)
+
+// Reasons a login through the identity provider gets no account
+var (
+	ErrNoAccess     = errors.New("your account at the identity provider has no access to Gyro")
+	ErrLocalAccount = errors.New("an account with this email logs in with a password, an admin has to allow single sign-on for it")
+	ErrOtherSubject = errors.New("the account with this email belongs to another user of the identity provider")
+)
+
+// Account returns the account of a user after a login. On their first login a new account is made in
+// organization (just-in-time provisioning). An existing account is only used if it logs in through single
+// sign-on, as this subject or, once an admin allowed it, as nobody yet; a local account with the same email
+// is refused, an email alone doesn't prove who owns it. With a role map the role follows the groups, but a
+// user in none of them keeps their role: the default role is only given to new accounts.
+func Account(identity Identity, existing *schema.User, organization string) (schema.User, error) {
+	role, ok := Role(identity)
+	if !ok {
+		return schema.User{}, ErrNoAccess
+	}
+	if existing == nil {
+		return schema.User{
+			Email:        identity.Email,
+			Role:         role,
+			Organization: organization,
+			DisplayName:  identity.Name,
+			Provider:     schema.ProviderOIDC,
+			Subject:      identity.Subject,
+		}, nil
+	}
+
+	switch {
+	case existing.Provider != schema.ProviderOIDC:
+		return schema.User{}, ErrLocalAccount
+	case existing.Subject != "" && existing.Subject != identity.Subject:
+		return schema.User{}, ErrOtherSubject
+	}
+	account := *existing
+	account.Subject = identity.Subject
+	if account.DisplayName == "" {
+		account.DisplayName = identity.Name
+	}
+	if mapped := groupRole(identity); mapped != "" {
+		account.Role = mapped
+	}
+	return account, nil
+}
+
+// Role maps the identity provider's groups to a Gyro role using the role map (OIDC_ROLE_MAP), e.g.
+// "gyro-admins=admin,gyro-engineers=engineer". The most privileged matching role wins.
+// Users in none of the groups get the default role (OIDC_DEFAULT_ROLE), or are refused if it is "none".
+func Role(identity Identity) (string, bool) {
+	if role := groupRole(identity); role != "" {
+		return role, true
+	}
+
+	switch fallback := settings.DefaultRole; fallback {
+	case "none":
+		return "", false
+	default:
+		return fallback, auth.ValidRole(fallback)
+	}
+}
+
+// groupRole returns the most privileged role mapped from the groups of a user, "" if none is mapped
+func groupRole(identity Identity) string {
+	mapping := roleMap()
+	role := ""
+	for _, group := range identity.Groups {
+		mapped, ok := mapping[group]
+		if ok && (role == "" || auth.HasRole(schema.User{Role: mapped}, role)) {
+			role = mapped
+		}
+	}
+	return role
+}
+
+// roleMap parses the role map, ignoring entries with unknown roles
+func roleMap() map[string]string {
+	mapping := make(map[string]string)
+	for _, entry := range strings.Split(settings.RoleMap, ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && auth.ValidRole(role) {
+			mapping[strings.TrimSpace(group)] = role
+		}
+	}
+	return mapping
+}
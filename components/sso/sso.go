@@ -0,0 +1,221 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"GOLANG_SERVER/components/config"
+	"GOLANG_SERVER/components/logging"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// How long a user may take at the identity provider before the login has to be started again
+const pendingTTL = 10 * time.Minute
+
+// Name of the cookie binding a login to the browser that started it
+const stateCookie = "gyro_oidc_state"
+
+// Identity is what the identity provider tells about the user after a successful login
+type Identity struct {
+	Subject string
+	Email   string
+	Name    string
+	Groups  []string
+}
+
+// pending is a login waiting for the identity provider to redirect back
+type pending struct {
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+var logger = logging.For("sso")
+
+var (
+	provider     *oidc.Provider
+	verifier     *oidc.IDTokenVerifier
+	oauth2Config oauth2.Config
+	settings     config.OIDC
+
+	logins      = make(map[string]pending)
+	loginsMutex sync.Mutex
+)
+
+// Setup discovers the identity provider of the configuration. Without an issuer single sign-on stays disabled.
+// The redirect URL is this server's /oidc/callback as the provider reaches it; Scopes are asked for besides
+// openid, email and profile, e.g. "groups".
+func Setup(oidcConfig config.OIDC) error {
+	issuer := oidcConfig.Issuer
+	if issuer == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	discovered, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return fmt.Errorf("discovering %s: %w", issuer, err)
+	}
+
+	scopes := []string{oidc.ScopeOpenID, "email", "profile"}
+	scopes = append(scopes, strings.Fields(oidcConfig.Scopes)...)
+	oauth2Config = oauth2.Config{
+		ClientID:     oidcConfig.ClientID,
+		ClientSecret: oidcConfig.ClientSecret,
+		RedirectURL:  oidcConfig.RedirectURL,
+		Endpoint:     discovered.Endpoint(),
+		Scopes:       scopes,
+	}
+	verifier = discovered.Verifier(&oidc.Config{ClientID: oauth2Config.ClientID})
+	settings = oidcConfig
+	provider = discovered
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			sweep()
+		}
+	}()
+	logger.Info("Single sign-on enabled", "issuer", issuer)
+	return nil
+}
+
+// Enabled reports whether an identity provider is configured
+func Enabled() bool {
+	return provider != nil
+}
+
+// Begin redirects the browser to the identity provider. The login is protected by a random
+// state bound to the browser with a cookie, a nonce, and a PKCE code verifier that never leaves the server.
+func Begin(w http.ResponseWriter, r *http.Request) error {
+	state, err := randomString()
+	if err != nil {
+		return err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return err
+	}
+	codeVerifier := oauth2.GenerateVerifier()
+
+	loginsMutex.Lock()
+	logins[state] = pending{verifier: codeVerifier, nonce: nonce, expires: time.Now().Add(pendingTTL)}
+	loginsMutex.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(pendingTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	url := oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier))
+	http.Redirect(w, r, url, http.StatusFound)
+	return nil
+}
+
+// Finish handles the identity provider's redirect back: it checks the state, exchanges the code
+// and verifies the ID token, returning who logged in
+func Finish(w http.ResponseWriter, r *http.Request) (Identity, error) {
+	query := r.URL.Query()
+	if message := query.Get("error"); message != "" {
+		return Identity{}, fmt.Errorf("identity provider: %s %s", message, query.Get("error_description"))
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || cookie.Value != state {
+		return Identity{}, errors.New("login was not started from this browser")
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Value: "", Path: "/", MaxAge: -1})
+
+	// * a state is single use
+	loginsMutex.Lock()
+	login, ok := logins[state]
+	delete(logins, state)
+	loginsMutex.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return Identity{}, errors.New("login expired, please try again")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	token, err := oauth2Config.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("exchanging code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("identity provider returned no ID token")
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("verifying ID token: %w", err)
+	}
+	if idToken.Nonce != login.nonce {
+		return Identity{}, errors.New("ID token nonce does not match")
+	}
+
+	return identityOf(idToken)
+}
+
+// identityOf reads the standard claims and the configured groups claim of an ID token
+func identityOf(idToken *oidc.IDToken) (Identity, error) {
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{Subject: idToken.Subject}
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	if identity.Email == "" {
+		return Identity{}, errors.New("ID token has no email claim, ask for the email scope")
+	}
+	// * an unverified email could be anybody's, accounts are matched by email
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return Identity{}, errors.New("email is not verified at the identity provider")
+	}
+
+	switch groups := claims[settings.GroupsClaim].(type) {
+	case []any:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = strings.Fields(groups)
+	}
+	return identity, nil
+}
+
+func sweep() {
+	loginsMutex.Lock()
+	defer loginsMutex.Unlock()
+	now := time.Now()
+	for state, login := range logins {
+		if now.After(login.expires) {
+			delete(logins, state)
+		}
+	}
+}
+
+func randomString() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
@@ -0,0 +1,238 @@
+package sso
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"GOLANG_SERVER/components/config"
+	"GOLANG_SERVER/components/schema"
+
+	"golang.org/x/oauth2"
+)
+
+// startProvider starts a mock identity provider logging everybody in as identity, and sets up
+// single sign-on against it
+func startProvider(t *testing.T, identity Identity) *MockProvider {
+	t.Helper()
+	mock, err := NewMockProvider("127.0.0.1:0", identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mock.Close() })
+
+	err = Setup(config.OIDC{
+		Issuer:       mock.Issuer(),
+		ClientID:     "gyro",
+		ClientSecret: "secret",
+		RedirectURL:  "http://gyro.test/oidc/callback",
+		GroupsClaim:  "groups",
+		RoleMap:      "gyro-admins=admin,gyro-engineers=engineer",
+		DefaultRole:  schema.RoleViewer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mock
+}
+
+// login runs a login from Begin to Finish like a browser would. tamper runs before the identity
+// provider redirects back, with the state of the login and the callback request.
+func login(t *testing.T, tamper func(state string, callback *http.Request)) (Identity, error) {
+	t.Helper()
+	begin := httptest.NewRecorder()
+	if err := Begin(begin, httptest.NewRequest(http.MethodGet, "/oidc/login", nil)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := begin.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != stateCookie {
+		t.Fatalf("cookies = %v, want the state cookie", cookies)
+	}
+
+	// * the identity provider approves at once and redirects back with a code
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	authorized, err := client.Get(begin.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized.Body.Close()
+	callbackURL := authorized.Header.Get("Location")
+	if !strings.HasPrefix(callbackURL, "http://gyro.test/oidc/callback?") {
+		t.Fatalf("identity provider redirected to %q (%s)", callbackURL, authorized.Status)
+	}
+
+	callback := httptest.NewRequest(http.MethodGet, callbackURL, nil)
+	callback.AddCookie(cookies[0])
+	if tamper != nil {
+		tamper(callback.URL.Query().Get("state"), callback)
+	}
+	return Finish(httptest.NewRecorder(), callback)
+}
+
+func TestLogin(t *testing.T) {
+	startProvider(t, Identity{Subject: "sub-1", Email: "ada@example.com", Name: "Ada", Groups: []string{"gyro-engineers"}})
+
+	identity, err := login(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "sub-1" || identity.Email != "ada@example.com" || identity.Name != "Ada" {
+		t.Fatalf("identity = %+v", identity)
+	}
+	if len(identity.Groups) != 1 || identity.Groups[0] != "gyro-engineers" {
+		t.Fatalf("groups = %v, want [gyro-engineers]", identity.Groups)
+	}
+
+	// * the new account is provisioned from the identity, with the role mapped from its groups
+	account, err := Account(identity, nil, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Email != "ada@example.com" || account.Role != schema.RoleEngineer || account.Organization != "acme" ||
+		account.DisplayName != "Ada" || account.Provider != schema.ProviderOIDC || account.Subject != "sub-1" {
+		t.Fatalf("account = %+v, want an engineer of acme signing in as sub-1", account)
+	}
+}
+
+func TestLoginRefused(t *testing.T) {
+	mock := startProvider(t, Identity{Subject: "sub-1", Email: "ada@example.com"})
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		tamper func(state string, callback *http.Request)
+		err    string
+	}{
+		{
+			name: "state of another browser",
+			tamper: func(state string, callback *http.Request) {
+				callback.Header.Set("Cookie", stateCookie+"=someone-else")
+			},
+			err: "not started from this browser",
+		},
+		{
+			name: "state used twice",
+			tamper: func(state string, callback *http.Request) {
+				loginsMutex.Lock()
+				delete(logins, state)
+				loginsMutex.Unlock()
+			},
+			err: "login expired",
+		},
+		{
+			name: "wrong PKCE verifier",
+			tamper: func(state string, callback *http.Request) {
+				loginsMutex.Lock()
+				login := logins[state]
+				login.verifier = oauth2.GenerateVerifier()
+				logins[state] = login
+				loginsMutex.Unlock()
+			},
+			err: "exchanging code",
+		},
+		{name: "wrong nonce", claims: map[string]any{"nonce": "replayed"}, err: "nonce does not match"},
+		{name: "unverified email", claims: map[string]any{"email_verified": false}, err: "email is not verified"},
+		{name: "no email", claims: map[string]any{"email": ""}, err: "no email claim"},
+		{name: "other audience", claims: map[string]any{"aud": "another-app"}, err: "verifying ID token"},
+	}
+	for _, test := range tests {
+		mock.mutex.Lock()
+		mock.Claims = test.claims
+		mock.mutex.Unlock()
+
+		_, err := login(t, test.tamper)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestRole(t *testing.T) {
+	settings = config.OIDC{RoleMap: "gyro-admins=admin, gyro-engineers=engineer, bad=root", DefaultRole: schema.RoleViewer}
+	t.Cleanup(func() { settings = config.OIDC{} })
+
+	tests := []struct {
+		groups      []string
+		defaultRole string
+		role        string
+		ok          bool
+	}{
+		{[]string{"gyro-engineers", "gyro-admins"}, schema.RoleViewer, schema.RoleAdmin, true},
+		{[]string{"gyro-engineers", "other"}, schema.RoleViewer, schema.RoleEngineer, true},
+		{[]string{"other", "bad"}, schema.RoleViewer, schema.RoleViewer, true},
+		{nil, "none", "", false},
+		{[]string{"gyro-admins"}, "none", schema.RoleAdmin, true},
+	}
+	for _, test := range tests {
+		settings.DefaultRole = test.defaultRole
+		role, ok := Role(Identity{Groups: test.groups})
+		if role != test.role || ok != test.ok {
+			t.Errorf("Role(%v) with default %q = %q, %v, want %q, %v", test.groups, test.defaultRole, role, ok, test.role, test.ok)
+		}
+	}
+}
+
+func TestAccount(t *testing.T) {
+	settings = config.OIDC{RoleMap: "gyro-admins=admin,gyro-engineers=engineer", DefaultRole: schema.RoleViewer}
+	t.Cleanup(func() { settings = config.OIDC{} })
+
+	operator := schema.User{Email: "op@example.com", Role: schema.RoleAdmin, Organization: "default", Provider: schema.ProviderOIDC, Subject: "sub-op"}
+	tests := []struct {
+		name     string
+		identity Identity
+		existing schema.User
+		role     string
+		err      error
+	}{
+		{
+			name:     "local account with the same email",
+			identity: Identity{Subject: "sub-op", Email: "op@example.com"},
+			existing: schema.User{Email: "op@example.com", Password: "hash", Role: schema.RoleAdmin},
+			err:      ErrLocalAccount,
+		},
+		{
+			name:     "account of another subject",
+			identity: Identity{Subject: "sub-other", Email: "op@example.com"},
+			existing: operator,
+			err:      ErrOtherSubject,
+		},
+		{
+			name:     "admin in no mapped group keeps the role",
+			identity: Identity{Subject: "sub-op", Email: "op@example.com"},
+			existing: operator,
+			role:     schema.RoleAdmin,
+		},
+		{
+			name:     "role follows a mapped group",
+			identity: Identity{Subject: "sub-op", Email: "op@example.com", Groups: []string{"gyro-engineers"}},
+			existing: operator,
+			role:     schema.RoleEngineer,
+		},
+		{
+			name:     "account an admin allowed single sign-on for",
+			identity: Identity{Subject: "sub-new", Email: "op@example.com"},
+			existing: schema.User{Email: "op@example.com", Role: schema.RoleEngineer, Provider: schema.ProviderOIDC},
+			role:     schema.RoleEngineer,
+		},
+	}
+	for _, test := range tests {
+		account, err := Account(test.identity, &test.existing, "acme")
+		switch {
+		case test.err != nil && err != test.err:
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.err)
+		case test.err == nil && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err == nil && (account.Role != test.role || account.Subject != test.identity.Subject || account.Organization != test.existing.Organization):
+			t.Errorf("%s: account = %+v, want role %s and subject %s in %q", test.name, account, test.role, test.identity.Subject, test.existing.Organization)
+		}
+	}
+
+	settings.DefaultRole = "none"
+	if _, err := Account(Identity{Subject: "sub-1", Email: "new@example.com"}, nil, "acme"); err != ErrNoAccess {
+		t.Errorf("user in no group with default role none: error = %v, want %v", err, ErrNoAccess)
+	}
+}
@@ -0,0 +1,113 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters understood by every authenticator app
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// Skew is how many periods before and after now are accepted, to tolerate clock drift
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random 160-bit secret, base32 encoded
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// ProvisioningURI returns the otpauth:// URI authenticator apps read from a QR code
+func ProvisioningURI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period.Seconds())))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Code returns the code of a secret for the period containing t
+func Code(secret string, t time.Time) (string, error) {
+	return code(secret, Step(t))
+}
+
+// Step returns the number of the period containing t
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Validate checks a code against the periods around t and returns the step it matched,
+// so callers can refuse a code that was already used
+func Validate(secret string, input string, t time.Time) (int64, bool) {
+	input = strings.TrimSpace(input)
+	if len(input) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		expected, err := code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(input)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// code is the HOTP value (RFC 4226) of the step counter
+func code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0F
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7FFFFFFF
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
+
+// GenerateRecoveryCodes returns n random single-use codes such as "k3f9-x2m8-q7rt"
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	codes := make([]string, n)
+	for i := range codes {
+		random := make([]byte, 12)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		var builder strings.Builder
+		for j, b := range random {
+			if j > 0 && j%4 == 0 {
+				builder.WriteByte('-')
+			}
+			builder.WriteByte(alphabet[int(b)%len(alphabet)])
+		}
+		codes[i] = builder.String()
+	}
+	return codes, nil
+}
@@ -0,0 +1,111 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/response"
+	"GOLANG_SERVER/components/schema"
+)
+
+// * slugs and MQTT prefixes are single topic levels without wildcards
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Organizations lists (GET) or creates (POST) organizations, only the operator may manage them
+func Organizations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	admin, _ := auth.UserFrom(r)
+	if !auth.IsOperator(admin) {
+		response.Error(w, http.StatusForbidden, response.CodeForbidden, "Forbidden")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		organizations, err := db.GetOrganizations()
+		if err != nil {
+			response.Err(w, r, err)
+			return
+		}
+		response.JSON(w, http.StatusOK, organizations)
+
+	case http.MethodPost:
+		var organization schema.Organization
+		if err := json.NewDecoder(r.Body).Decode(&organization); err != nil {
+			response.BadRequest(w, err)
+			return
+		}
+		if organization.MQTTPrefix == "" {
+			organization.MQTTPrefix = organization.Slug
+		}
+		if !slugPattern.MatchString(organization.Slug) || !slugPattern.MatchString(organization.MQTTPrefix) || organization.MQTTPrefix == "sample" {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid slug or MQTT prefix")
+			return
+		}
+
+		for _, role := range organization.TwoFactorRoles {
+			if !auth.ValidRole(role) {
+				response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid role: "+role)
+				return
+			}
+		}
+
+		if _, err := db.StoreOrganization(organization); err != nil {
+			audit.Record(r, admin.Email, audit.ActionOrganizationAdd, organization.Slug, audit.OutcomeFailure)
+			response.Error(w, http.StatusConflict, response.CodeConflict, err.Error())
+			return
+		}
+		audit.Record(r, admin.Email, audit.ActionOrganizationAdd, organization.Slug, audit.OutcomeSuccess)
+
+		logger.InfoContext(r.Context(), "Organization created", "organization", organization.Slug)
+		response.JSON(w, http.StatusCreated, organization)
+
+	default:
+		response.MethodNotAllowed(w)
+	}
+}
+
+// SetOrganization lets the operator move a user into an organization
+func SetOrganization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	admin, _ := auth.UserFrom(r)
+	if !auth.IsOperator(admin) {
+		response.Error(w, http.StatusForbidden, response.CodeForbidden, "Forbidden")
+		return
+	}
+
+	var req struct {
+		Email        string `json:"Email"`
+		Organization string `json:"Organization"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	if req.Email == admin.Email {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Operators can't move themselves")
+		return
+	}
+
+	if _, err := db.SetUserOrganization(req.Email, req.Organization); err != nil {
+		audit.Record(r, admin.Email, audit.ActionOrganizationMove, req.Email+" -> "+req.Organization, audit.OutcomeFailure)
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, err.Error())
+		return
+	}
+	audit.Record(r, admin.Email, audit.ActionOrganizationMove, req.Email+" -> "+req.Organization, audit.OutcomeSuccess)
+
+	logger.InfoContext(r.Context(), "Organization changed", "email", req.Email, "organization", req.Organization)
+	reply := map[string]string{"message": "Organization updated", "Email": req.Email, "Organization": req.Organization}
+	response.JSON(w, http.StatusOK, reply)
+}
@@ -0,0 +1,171 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/response"
+	"GOLANG_SERVER/components/schema"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// How long an emailed reset link can be used
+const passwordResetTTL = 30 * time.Minute
+
+// ForgotPassword emails a single-use password reset link. It answers the same whether
+// or not the account exists, so it can't be used to find out who has an account.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+	if !passwordLoginEnabled(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Parse the request body to get user details
+	var userDetails map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&userDetails); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+
+	// Handle both lowercase and uppercase keys
+	email := userDetails["email"]
+	if email == "" {
+		email = userDetails["Email"]
+	}
+
+	if !allowEmail(w, r, "password-reset", email) {
+		return
+	}
+
+	// * the link is sent in the background, neither an error nor the time the mail server takes tells
+	// * whether the account exists
+	go sendPasswordReset(context.WithoutCancel(r.Context()), email)
+
+	reply := map[string]string{"message": "If the account exists, a password reset link has been sent to its email."}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// sendPasswordReset emails a reset link if the account exists, logging what went wrong
+func sendPasswordReset(ctx context.Context, email string) {
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			logger.InfoContext(ctx, "Password reset requested for unknown email", "email", email)
+		} else {
+			logger.ErrorContext(ctx, "Error looking up the account of a password reset", "email", email, "error", err)
+		}
+		return
+	}
+	// * single sign-on accounts have no password, a reset would give them one to skip the identity provider
+	if user.Provider == schema.ProviderOIDC {
+		logger.InfoContext(ctx, "Password reset requested for a single sign-on account", "email", email)
+		return
+	}
+	token, err := db.CreatePasswordReset(email, passwordResetTTL)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error creating a password reset", "email", email, "error", err)
+		return
+	}
+	if err := SendPasswordResetEmail(email, token); err != nil {
+		logger.ErrorContext(ctx, "Error sending a password reset email", "email", email, "error", err)
+	}
+}
+
+// ResetPassword sets a new password using an emailed reset token and signs the user out everywhere
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+	if !passwordLoginEnabled(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Token    string `json:"Token"`
+		Password string `json:"Password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	if len(req.Password) < 8 {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Password must be at least 8 characters")
+		return
+	}
+
+	email, err := db.UsePasswordReset(req.Token)
+	if err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+
+	// Hash the password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+	if _, err := db.SetUserPassword(email, string(hashedPassword)); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	// * whoever knew the old password must not stay logged in
+	if _, err := db.DeleteSessionsByEmail(email); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Password reset", "email", email)
+	audit.Record(r, email, audit.ActionPasswordReset, email, audit.OutcomeSuccess)
+	reply := map[string]string{"message": "Password has been reset. Please log in again."}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// SendPasswordResetEmail sends the reset link to the user's email. The link points at
+// PASSWORD_RESET_URL (the frontend's reset page) with the token as ?token=.
+func SendPasswordResetEmail(email string, token string) error {
+	link, err := url.Parse(authSettings.PasswordResetURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	emailData := struct {
+		Link    string
+		Minutes int
+	}{
+		Link:    link.String(),
+		Minutes: int(passwordResetTTL.Minutes()),
+	}
+
+	emailTemplate := `
+	<html>
+		<head></head>
+		<body>
+			<h1>Reset your Gyro password</h1>
+			<p>Someone asked to reset the password of this account. If it wasn't you, ignore this email.</p>
+			<p><a href="{{.Link}}">Reset password</a> (valid for {{.Minutes}} minutes, can be used once)</p>
+		</body>
+	</html>
+	`
+
+	return sendEmail(email, "Password reset", emailTemplate, emailData)
+}
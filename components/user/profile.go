@@ -0,0 +1,237 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/ratelimit"
+	"GOLANG_SERVER/components/response"
+	"GOLANG_SERVER/components/schema"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Longest accepted display name
+const maxDisplayNameLength = 100
+
+// Profile is what a user (or an admin) gets to see of an account, never the password hash or TOTP secrets
+type Profile struct {
+	Email         string                         `json:"Email"`
+	DisplayName   string                         `json:"DisplayName"`
+	Role          string                         `json:"Role"`
+	Organization  string                         `json:"Organization"`
+	Timezone      string                         `json:"Timezone"`
+	Notifications schema.NotificationPreferences `json:"Notifications"`
+	Provider      string                         `json:"Provider"`
+	TOTPEnabled   bool                           `json:"TOTPEnabled"`
+	Disabled      bool                           `json:"Disabled"`
+}
+
+func profileOf(user schema.User) Profile {
+	return Profile{
+		Email:         user.Email,
+		DisplayName:   user.DisplayName,
+		Role:          auth.RoleOf(user),
+		Organization:  user.Organization,
+		Timezone:      user.Timezone,
+		Notifications: user.Notifications,
+		Provider:      user.Provider,
+		TOTPEnabled:   user.TOTPEnabled,
+		Disabled:      user.Disabled,
+	}
+}
+
+// Me returns (GET) or updates (PUT) the profile of the logged-in user, or deletes their account (DELETE)
+func Me(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, _ := auth.UserFrom(r)
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		// * fields left out of the request keep their current value
+		req := struct {
+			DisplayName   string                         `json:"DisplayName"`
+			Timezone      string                         `json:"Timezone"`
+			Notifications schema.NotificationPreferences `json:"Notifications"`
+		}{user.DisplayName, user.Timezone, user.Notifications}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.BadRequest(w, err)
+			return
+		}
+		if len(req.DisplayName) > maxDisplayNameLength {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Display name is too long")
+			return
+		}
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Unknown timezone, use an IANA name such as Asia/Bangkok")
+			return
+		}
+
+		if _, err := db.UpdateProfile(user.Email, req.DisplayName, req.Timezone, req.Notifications); err != nil {
+			response.Err(w, r, err)
+			return
+		}
+		user.DisplayName, user.Timezone, user.Notifications = req.DisplayName, req.Timezone, req.Notifications
+	case http.MethodDelete:
+		deleteAccount(w, r, user)
+		return
+	default:
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, profileOf(user))
+}
+
+// ChangePassword sets a new password after checking the current one and signs out the user's other sessions
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+	if !passwordLoginEnabled(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		CurrentPassword string `json:"CurrentPassword"`
+		NewPassword     string `json:"NewPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	if len(req.NewPassword) < 8 {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Password must be at least 8 characters")
+		return
+	}
+
+	user, _ := auth.UserFrom(r)
+	if !CheckPassword(w, r, user, req.CurrentPassword) {
+		return
+	}
+
+	// Hash the password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+	if _, err := db.SetUserPassword(user.Email, string(hashedPassword)); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+	if _, err := db.DeleteOtherSessions(user.Email, auth.Token(r)); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Password changed", "email", user.Email)
+	audit.Record(r, user.Email, audit.ActionPasswordChange, user.Email, audit.OutcomeSuccess)
+	reply := map[string]string{"message": "Password changed. Other sessions have been signed out."}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// ChangeEmail moves the account of the logged-in user to a new email after checking their password
+func ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+	if !passwordLoginEnabled(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Email    string `json:"Email"`
+		Password string `json:"Password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	if address, err := mail.ParseAddress(req.Email); err != nil || address.Address != req.Email {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid email")
+		return
+	}
+
+	user, _ := auth.UserFrom(r)
+	if !CheckPassword(w, r, user, req.Password) {
+		return
+	}
+
+	if _, err := db.ChangeUserEmail(user.Email, req.Email); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Email changed", "email", user.Email, "new_email", req.Email)
+	audit.Record(r, req.Email, audit.ActionEmailChange, user.Email+" -> "+req.Email, audit.OutcomeSuccess)
+	reply := map[string]string{"message": "Email changed", "Email": req.Email}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// deleteAccount deletes the logged-in user's account after checking their password;
+// the last admin of an organization has to hand over first
+func deleteAccount(w http.ResponseWriter, r *http.Request, user schema.User) {
+	var req struct {
+		Password string `json:"Password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	if !CheckPassword(w, r, user, req.Password) {
+		return
+	}
+
+	if auth.RoleOf(user) == schema.RoleAdmin && user.Organization != "" {
+		if count, err := db.CountAdmins(user.Organization); err != nil {
+			response.Err(w, r, err)
+			return
+		} else if count <= 1 {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "The last admin of an organization can't delete their account")
+			return
+		}
+	}
+
+	if _, err := db.DeleteUser(user.Organization, user.Email); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Account deleted", "email", user.Email)
+	audit.Record(r, user.Email, audit.ActionUserDelete, user.Email, audit.OutcomeSuccess)
+	reply := map[string]string{"message": "Account deleted"}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// CheckPassword confirms the current password before a sensitive change, answering itself when it is wrong.
+// Wrong passwords count as failed logins, so a stolen session can't be used to guess the password.
+func CheckPassword(w http.ResponseWriter, r *http.Request, user schema.User, password string) bool {
+	if user.Provider == schema.ProviderOIDC {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Accounts using single sign-on have no password, manage them at the identity provider")
+		return false
+	}
+	if wait := ratelimit.LoginWait(user.Email); wait > 0 {
+		ratelimit.TooManyRequests(w, wait)
+		return false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		loginFailed(r, user.Email)
+		response.Error(w, http.StatusUnauthorized, response.CodeInvalidCredentials, "Invalid password")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/response"
+	"GOLANG_SERVER/components/schema"
+	"GOLANG_SERVER/components/sso"
+)
+
+// SSOLogin starts a single sign-on login by redirecting the browser to the identity provider
+func SSOLogin(w http.ResponseWriter, r *http.Request) {
+	if !sso.Enabled() {
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, "Single sign-on is not configured")
+		return
+	}
+	if err := sso.Begin(w, r); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+}
+
+// SSOCallback finishes a single sign-on login: the user is created on their first login and gets a session.
+// With OIDC_SUCCESS_URL set the browser is sent there with the token in the URL fragment, otherwise the
+// token is returned as JSON like /login does.
+func SSOCallback(w http.ResponseWriter, r *http.Request) {
+	if !sso.Enabled() {
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, "Single sign-on is not configured")
+		return
+	}
+
+	identity, err := sso.Finish(w, r)
+	if err != nil {
+		logger.WarnContext(r.Context(), "Single sign-on failed", "error", err)
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Single sign-on failed: "+err.Error())
+		return
+	}
+
+	var existing *schema.User
+	if found, err := db.GetUserByEmail(identity.Email); err == nil {
+		existing = &found
+	} else if !errors.Is(err, db.ErrNotFound) {
+		response.Err(w, r, err)
+		return
+	}
+
+	// * just-in-time provisioning: new accounts land in OIDC_ORGANIZATION, or wait for an admin to assign them
+	user, err := sso.Account(identity, existing, oidcSettings.Organization)
+	if err != nil {
+		audit.Record(r, identity.Email, audit.ActionLogin, identity.Email, audit.OutcomeDenied)
+		response.Error(w, http.StatusForbidden, response.CodeForbidden, err.Error())
+		return
+	}
+	if err := db.ProvisionUser(user, existing == nil); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+	if user.Disabled {
+		audit.Record(r, user.Email, audit.ActionLogin, user.Email, audit.OutcomeDenied)
+		response.Error(w, http.StatusForbidden, response.CodeAccountDisabled, "Account disabled")
+		return
+	}
+
+	token, err := db.CreateSession(user.Email, sessionTTL())
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+	audit.Record(r, user.Email, audit.ActionLogin, user.Email, audit.OutcomeSuccess)
+	logger.InfoContext(r.Context(), "User logged in with single sign-on", "email", user.Email)
+
+	if success := oidcSettings.SuccessURL; success != "" {
+		// * the fragment never reaches servers or their logs
+		fragment := url.Values{"token": {token}, "role": {auth.RoleOf(user)}}
+		http.Redirect(w, r, success+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	reply := map[string]string{"message": "Login successful", "Token": token, "Role": auth.RoleOf(user)}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// AllowSSO lets an existing local account log in through single sign-on: its password is removed and the
+// next single sign-on login with its email takes it over. A login alone never converts a local account.
+func AllowSSO(w http.ResponseWriter, r *http.Request) {
+	if !sso.Enabled() {
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, "Single sign-on is not configured")
+		return
+	}
+
+	var req struct {
+		Email string `json:"Email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+
+	admin, _ := auth.UserFrom(r)
+	if _, err := db.AllowSSO(auth.Organization(r), req.Email); err != nil {
+		audit.Record(r, admin.Email, audit.ActionSSOAllow, req.Email, audit.OutcomeFailure)
+		response.Err(w, r, err)
+		return
+	}
+	audit.Record(r, admin.Email, audit.ActionSSOAllow, req.Email, audit.OutcomeSuccess)
+
+	logger.InfoContext(r.Context(), "Single sign-on allowed", "email", req.Email)
+	reply := map[string]string{"message": "The account now logs in through single sign-on", "Email": req.Email}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// passwordLoginEnabled reports whether local passwords may be used; PASSWORD_LOGIN=false leaves
+// single sign-on as the only way in. It answers 403 itself when they may not.
+func passwordLoginEnabled(w http.ResponseWriter) bool {
+	if !authSettings.PasswordLogin {
+		response.Error(w, http.StatusForbidden, response.CodeForbidden, "Password login is disabled, use single sign-on")
+		return false
+	}
+	return true
+}
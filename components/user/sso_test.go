@@ -0,0 +1,54 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"GOLANG_SERVER/components/config"
+	"GOLANG_SERVER/components/response"
+)
+
+func TestPasswordLoginDisabled(t *testing.T) {
+	t.Setenv("PASSWORD_LOGIN", "false")
+	t.Setenv("OIDC_ISSUER", "http://127.0.0.1:9096")
+	// * the rest of the configuration is missing here, only the settings of this package matter
+	cfg, _ := config.Load()
+	if cfg.Auth.PasswordLogin {
+		t.Fatal("PASSWORD_LOGIN=false left password login enabled")
+	}
+	Configure(cfg.Auth, cfg.SMTP, cfg.OIDC)
+	t.Cleanup(func() { Configure(config.Auth{PasswordLogin: true}, config.SMTP{}, config.OIDC{}) })
+
+	handlers := map[string]http.HandlerFunc{
+		"/register":        Register,
+		"/login":           Login,
+		"/login/2fa":       LoginTwoFactor,
+		"/password/forgot": ForgotPassword,
+		"/password/reset":  ResetPassword,
+		"/me/password":     ChangePassword,
+		"/me/email":        ChangeEmail,
+	}
+	for path, handler := range handlers {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(`{"Email": "ada@example.com", "Password": "correct horse"}`)
+		handler(w, httptest.NewRequest(http.MethodPost, path, body))
+
+		var envelope response.Envelope
+		json.NewDecoder(w.Body).Decode(&envelope)
+		if w.Code != http.StatusForbidden || envelope.Error.Code != response.CodeForbidden {
+			t.Errorf("%s: %d %+v, want 403 forbidden", path, w.Code, envelope.Error)
+		}
+	}
+}
+
+func TestPasswordLoginNeedsSingleSignOn(t *testing.T) {
+	t.Setenv("PASSWORD_LOGIN", "false")
+	t.Setenv("OIDC_ISSUER", "")
+	_, err := config.Load()
+	if err == nil || !strings.Contains(err.Error(), "PASSWORD_LOGIN=false needs single sign-on") {
+		t.Fatalf("error = %v, want PASSWORD_LOGIN=false refused without an identity provider", err)
+	}
+}
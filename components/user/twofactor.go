@@ -0,0 +1,270 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/ratelimit"
+	"GOLANG_SERVER/components/response"
+	"GOLANG_SERVER/components/totp"
+)
+
+// Two-factor settings: the name shown in authenticator apps, how long the second login step
+// may take, and how many recovery codes are handed out
+const (
+	totpIssuer        = "Gyro"
+	challengeTTL      = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// EnrollTwoFactor creates a new TOTP secret for the logged-in user; it only becomes active
+// once a code from the authenticator app is confirmed with ConfirmTwoFactor
+func EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	user, _ := auth.UserFrom(r)
+	if user.TOTPEnabled {
+		response.Error(w, http.StatusConflict, response.CodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+	if _, err := db.SetTOTPPendingSecret(user.Email, secret); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	// * the URI is what the QR code shown to the user encodes
+	reply := map[string]string{
+		"Secret":          secret,
+		"ProvisioningURI": totp.ProvisioningURI(totpIssuer, user.Email, secret),
+	}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// ConfirmTwoFactor activates the pending secret with a first code and returns the recovery codes, once
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Code string `json:"Code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+
+	user, _ := auth.UserFrom(r)
+	if user.TOTPPendingSecret == "" {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Start the enrollment first")
+		return
+	}
+	if _, ok := totp.Validate(user.TOTPPendingSecret, req.Code, time.Now()); !ok {
+		response.Error(w, http.StatusUnauthorized, response.CodeInvalidCredentials, "Invalid code")
+		return
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+	if _, err := db.EnableTOTP(user.Email, user.TOTPPendingSecret, codes); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Two-factor authentication enabled", "email", user.Email)
+	audit.Record(r, user.Email, audit.ActionTwoFactorEnable, user.Email, audit.OutcomeSuccess)
+	reply := map[string]any{"message": "Two-factor authentication enabled. Keep the recovery codes somewhere safe, they are not shown again.", "RecoveryCodes": codes}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// DisableTwoFactor turns two-factor authentication off after checking a current code,
+// unless the user's organization requires it for their role
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Code string `json:"Code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+
+	user, _ := auth.UserFrom(r)
+	if !user.TOTPEnabled {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+	if required, err := auth.TwoFactorRequired(user); err != nil {
+		response.Err(w, r, err)
+		return
+	} else if required {
+		response.Error(w, http.StatusForbidden, response.CodeTwoFactorRequired, "Your organization requires two-factor authentication")
+		return
+	}
+	if !checkSecondFactor(user.Email, user.TOTPSecret, req.Code, "") {
+		response.Error(w, http.StatusUnauthorized, response.CodeInvalidCredentials, "Invalid code")
+		return
+	}
+
+	if _, err := db.DisableTOTP(user.Email); err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Two-factor authentication disabled", "email", user.Email)
+	audit.Record(r, user.Email, audit.ActionTwoFactorDisable, user.Email, audit.OutcomeSuccess)
+	reply := map[string]string{"message": "Two-factor authentication disabled"}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// LoginTwoFactor is the second login step: it exchanges the challenge returned by Login
+// and a TOTP or recovery code for a session
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+	if !passwordLoginEnabled(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Challenge    string `json:"Challenge"`
+		Code         string `json:"Code"`
+		RecoveryCode string `json:"RecoveryCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+
+	if ok, retryAfter := ratelimit.Allow("login-ip:"+ratelimit.ClientIP(r), loginLimitPerIP, loginWindow); !ok {
+		ratelimit.TooManyRequests(w, retryAfter)
+		return
+	}
+
+	challenge, err := db.GetChallenge(req.Challenge)
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Invalid or expired challenge")
+		return
+	}
+	// * wrong codes count as failed logins, so guessing codes runs into the same delays and lockout
+	if wait := ratelimit.LoginWait(challenge.Email); wait > 0 {
+		ratelimit.TooManyRequests(w, wait)
+		return
+	}
+
+	user, err := db.GetUserByEmail(challenge.Email)
+	if err != nil || !checkSecondFactor(user.Email, user.TOTPSecret, req.Code, req.RecoveryCode) {
+		loginFailed(r, challenge.Email)
+		response.Error(w, http.StatusUnauthorized, response.CodeInvalidCredentials, "Invalid code")
+		return
+	}
+	ratelimit.LoginSucceeded(user.Email)
+	audit.Record(r, user.Email, audit.ActionLogin, user.Email, audit.OutcomeSuccess)
+
+	// * a challenge is single use
+	if _, err := db.DeleteSession(req.Challenge); err != nil {
+		logger.ErrorContext(r.Context(), "Error deleting login challenge", "error", err)
+	}
+	token, err := db.CreateSession(user.Email, sessionTTL())
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	reply := map[string]string{"message": "Login successful", "Token": token, "Role": auth.RoleOf(user)}
+	logger.InfoContext(r.Context(), "User logged in", "email", user.Email, "two_factor", true)
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// SetTwoFactorRoles lets an admin choose which roles of their organization must use two-factor authentication
+func SetTwoFactorRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Roles []string `json:"Roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	for _, role := range req.Roles {
+		if !auth.ValidRole(role) {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid role: "+role)
+			return
+		}
+	}
+	if req.Roles == nil {
+		req.Roles = []string{}
+	}
+
+	// * the admin must not lock themselves out by requiring what they haven't set up
+	admin, _ := auth.UserFrom(r)
+	for _, role := range req.Roles {
+		if role == auth.RoleOf(admin) && !admin.TOTPEnabled {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Set up two-factor authentication for yourself first")
+			return
+		}
+	}
+
+	if _, err := db.SetTwoFactorRoles(admin.Organization, req.Roles); err != nil {
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, err.Error())
+		return
+	}
+	audit.Record(r, admin.Email, audit.ActionTwoFactorRoles, admin.Organization+": "+strings.Join(req.Roles, ","), audit.OutcomeSuccess)
+
+	logger.InfoContext(r.Context(), "Two-factor authentication roles set", "organization", admin.Organization, "roles", req.Roles)
+	reply := map[string]any{"message": "Two-factor requirement updated", "Organization": admin.Organization, "Roles": req.Roles}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// checkSecondFactor accepts a TOTP code that wasn't used before, or an unused recovery code
+func checkSecondFactor(email string, secret string, code string, recoveryCode string) bool {
+	if recoveryCode != "" {
+		ok, _ := db.UseRecoveryCode(email, recoveryCode)
+		return ok
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	// * the same code can't be replayed within its validity window
+	ok, _ = db.UseTOTPStep(email, step)
+	return ok
+}
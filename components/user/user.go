@@ -3,20 +3,53 @@ package user
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"log"
 	"math/rand"
 	"net/http"
 	"net/smtp"
+	"strconv"
+	"strings"
 	"time"
 
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/config"
 	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/ratelimit"
+	"GOLANG_SERVER/components/response"
 	"GOLANG_SERVER/components/schema"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Rate limits of the login and email sending endpoints
+const (
+	loginLimitPerIP      = 20
+	loginWindow          = 15 * time.Minute
+	emailLimitPerIP      = 5
+	emailLimitPerAddress = 3
+	emailWindow          = time.Hour
+)
+
+var logger = logging.For("user")
+
+// * settings injected by main
+var (
+	authSettings config.Auth
+	smtpSettings config.SMTP
+	oidcSettings config.OIDC
+)
+
+// Configure sets the login, email and single sign-on settings of the account endpoints
+func Configure(authConfig config.Auth, smtpConfig config.SMTP, oidcConfig config.OIDC) {
+	authSettings = authConfig
+	smtpSettings = smtpConfig
+	oidcSettings = oidcConfig
+}
+
 // GenerateOTP generates a random 6-digit OTP
 func GenerateOTP() string {
 	rand.Seed(time.Now().UnixNano())
@@ -26,17 +59,6 @@ func GenerateOTP() string {
 
 // SendOTPEmail sends an OTP to the user's email
 func SendOTPEmail(email, otp string) error {
-	log.Println("Sending OTP to email...")
-
-	from := "toonglar@gmail.com"      // Replace with your email address
-	password := "gbgs bzkr kdbg xvqe" // Replace with your app-specific password
-	smtpHost := "smtp.gmail.com"
-	smtpPort := "587"
-
-	// Set up authentication information.
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-
-	log.Println("Sending email to:", email)
 
 	// Dynamic content for the email
 	emailData := struct {
@@ -61,42 +83,66 @@ func SendOTPEmail(email, otp string) error {
 	</html>
 	`
 
+	if err := sendEmail(email, "OTP Verification", emailTemplate, emailData); err != nil {
+		return err
+	}
+	logger.Info("Sent OTP", "email", email)
+	return nil
+}
+
+// sendEmail renders an HTML template and sends it to one address
+func sendEmail(email string, subject string, emailTemplate string, emailData any) error {
+	if smtpSettings.Username == "" {
+		return errors.New("email is not configured, set SMTP_USERNAME and SMTP_PASSWORD")
+	}
+	from := smtpSettings.From
+	if from == "" {
+		from = smtpSettings.Username
+	}
+	smtpHost := smtpSettings.Host
+	smtpPort := strconv.Itoa(smtpSettings.Port)
+
+	// Set up authentication information.
+	smtpAuth := smtp.PlainAuth("", smtpSettings.Username, smtpSettings.Password, smtpHost)
+
+	logger.Debug("Sending email", "to", email, "subject", subject)
+
 	// Parse the template and generate HTML
 	tmpl, err := template.New("email").Parse(emailTemplate)
 	if err != nil {
-		log.Println("Error parsing email template:", err)
+		logger.Error("Error parsing email template", "error", err)
 		return err
 	}
 
 	var body bytes.Buffer
 	if err := tmpl.Execute(&body, emailData); err != nil {
-		log.Println("Error executing email template:", err)
+		logger.Error("Error executing email template", "error", err)
 		return err
 	}
 
 	// Set up email subject and content
 	to := []string{email}
-	msg := []byte("Subject: OTP Verification\r\n" +
+	msg := []byte("From: " + from + "\r\n" +
+		"To: " + email + "\r\n" +
+		"Subject: " + subject + "\r\n" +
 		"MIME-version: 1.0;\r\n" +
 		"Content-Type: text/html; charset=\"UTF-8\";\r\n\r\n" +
 		body.String())
 
 	// Send the email
-	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, msg)
+	err = smtp.SendMail(smtpHost+":"+smtpPort, smtpAuth, from, to, msg)
 	if err != nil {
-		log.Println("Error sending email:", err)
+		logger.Error("Error sending email", "to", email, "error", err)
 		return err
-	} else {
-		log.Println("Sent OTP to email successfully.")
-		return nil
 	}
+	return nil
 }
 
 // SendOTP sends an OTP to the user's email and returns the OTP
 func SendOTP(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost { // Allow only POST requests
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		response.MethodNotAllowed(w)
 		return
 	}
 
@@ -105,7 +151,7 @@ func SendOTP(w http.ResponseWriter, r *http.Request) {
 	// Parse the request body to get user details
 	var userDetails map[string]string
 	if err := json.NewDecoder(r.Body).Decode(&userDetails); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		response.BadRequest(w, err)
 		return
 	}
 
@@ -115,28 +161,32 @@ func SendOTP(w http.ResponseWriter, r *http.Request) {
 		email = userDetails["Email"]
 	}
 
+	// * every OTP is an email sent on someone's behalf, so both the client and the address are limited
+	if !allowEmail(w, r, "otp", email) {
+		return
+	}
+
 	// Generate OTP
 	otp := GenerateOTP()
 
 	// Send OTP to user's email
 	if err := SendOTPEmail(email, otp); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Err(w, r, err)
 		return
 	}
 
 	// Send a response
-	response := map[string]string{"message": "OTP sent successfully. Please check your email for the OTP.", "OTP": otp}
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	reply := map[string]string{"message": "OTP sent successfully. Please check your email for the OTP.", "OTP": otp}
+	response.JSON(w, http.StatusOK, reply)
 }
 
 // Register handles user registration
 func Register(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost { // Allow only POST requests
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		response.MethodNotAllowed(w)
+		return
+	}
+	if !passwordLoginEnabled(w) {
 		return
 	}
 
@@ -145,7 +195,7 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	// Parse the request body to get user details
 	var userDetails map[string]string
 	if err := json.NewDecoder(r.Body).Decode(&userDetails); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		response.BadRequest(w, err)
 		return
 	}
 
@@ -162,38 +212,42 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Err(w, r, err)
 		return
 	}
 
+	// * everyone starts as a viewer without organization until an admin adds them to one;
+	// * the first admin is made on the server with the promote command
+	role := schema.RoleViewer
+
 	// Convert the user details to a User struct
 	user := schema.User{
 		Email:    email,
 		Password: string(hashedPassword),
+		Role:     role,
 	}
 
 	// Save user details to database
 	if _, err := db.StoreUser(user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		audit.Record(r, email, audit.ActionRegister, email, audit.OutcomeFailure)
+		response.Err(w, r, err)
 		return
-	} else {
-		log.Println("User registered successfully.")
 	}
+	audit.Record(r, email, audit.ActionRegister, email, audit.OutcomeSuccess)
 
 	// Send a response
-	response := map[string]string{"message": "User registered successfully. Please check your email for the OTP."}
-	log.Println("User registered successfully.")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	reply := map[string]string{"message": "User registered successfully. Please check your email for the OTP."}
+	logger.InfoContext(r.Context(), "User registered", "email", email, "role", role)
+	response.JSON(w, http.StatusOK, reply)
 }
 
 // Login handles user login
 func Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost { // Allow only POST requests
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		response.MethodNotAllowed(w)
+		return
+	}
+	if !passwordLoginEnabled(w) {
 		return
 	}
 
@@ -202,7 +256,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	// Parse the request body to get user details
 	var userDetails map[string]string
 	if err := json.NewDecoder(r.Body).Decode(&userDetails); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		response.BadRequest(w, err)
 		return
 	}
 
@@ -216,26 +270,150 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		password = userDetails["Password"]
 	}
 
+	// * limit attempts per client, and slow down then lock out guessing on a single account
+	if ok, retryAfter := ratelimit.Allow("login-ip:"+ratelimit.ClientIP(r), loginLimitPerIP, loginWindow); !ok {
+		ratelimit.TooManyRequests(w, retryAfter)
+		return
+	}
+	if wait := ratelimit.LoginWait(email); wait > 0 {
+		ratelimit.TooManyRequests(w, wait)
+		return
+	}
+
 	// Check if user exists
 	user, err := db.Login(email, password)
 	if err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		loginFailed(r, email)
+		response.Error(w, http.StatusUnauthorized, response.CodeInvalidCredentials, "Invalid email or password")
 		return
 	}
 
 	// Compare the provided password with the stored hashed password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		loginFailed(r, email)
+		response.Error(w, http.StatusUnauthorized, response.CodeInvalidCredentials, "Invalid email or password")
+		return
+	}
+	if user.Disabled {
+		audit.Record(r, email, audit.ActionLogin, email, audit.OutcomeDenied)
+		response.Error(w, http.StatusForbidden, response.CodeAccountDisabled, "Account disabled")
+		return
+	}
+
+	// * with two-factor authentication the password only earns a short-lived challenge for LoginTwoFactor
+	if user.TOTPEnabled {
+		challenge, err := db.CreateChallenge(user.Email, challengeTTL)
+		if err != nil {
+			response.Err(w, r, err)
+			return
+		}
+		reply := map[string]any{"message": "Enter the code from your authenticator app", "TwoFactorRequired": true, "Challenge": challenge}
+		response.JSON(w, http.StatusOK, reply)
+		return
+	}
+	ratelimit.LoginSucceeded(email)
+
+	// Start a session, the token must be sent as "Authorization: Bearer <token>"
+	token, err := db.CreateSession(user.Email, sessionTTL())
+	if err != nil {
+		response.Err(w, r, err)
 		return
 	}
+	audit.Record(r, user.Email, audit.ActionLogin, user.Email, audit.OutcomeSuccess)
 
 	// Send a response
-	response := map[string]string{"message": "Login successful"}
-	log.Println("User logged in successfully.")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	reply := map[string]string{"message": "Login successful", "Token": token, "Role": auth.RoleOf(user)}
+	logger.InfoContext(r.Context(), "User logged in", "email", user.Email)
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// Logout ends the session of the request's bearer token
+func Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	session, _ := db.GetSession(auth.Token(r))
+	if _, err := db.DeleteSession(auth.Token(r)); err != nil {
+		response.Err(w, r, err)
 		return
 	}
+	if session.Email != "" {
+		audit.Record(r, session.Email, audit.ActionLogout, session.Email, audit.OutcomeSuccess)
+	}
+
+	response.Message(w, http.StatusOK, "Logged out")
+}
+
+// SetRole lets an admin change the role of a user of their organization
+func SetRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Email string `json:"Email"`
+		Role  string `json:"Role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Invalid role")
+		return
+	}
+
+	// * an admin can't demote themselves, so there is always at least one admin left
+	admin, _ := auth.UserFrom(r)
+	if admin.Email == req.Email && req.Role != schema.RoleAdmin {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Admins can't change their own role")
+		return
+	}
+
+	if _, err := db.SetUserRole(auth.Organization(r), req.Email, req.Role); err != nil {
+		audit.Record(r, admin.Email, audit.ActionRoleChange, req.Email+" -> "+req.Role, audit.OutcomeFailure)
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, err.Error())
+		return
+	}
+	audit.Record(r, admin.Email, audit.ActionRoleChange, req.Email+" -> "+req.Role, audit.OutcomeSuccess)
+
+	logger.InfoContext(r.Context(), "Role changed", "email", req.Email, "role", req.Role)
+	reply := map[string]string{"message": "Role updated", "Email": req.Email, "Role": req.Role}
+	response.JSON(w, http.StatusOK, reply)
+}
+
+// sessionTTL returns how long a login session lasts (SESSION_TTL)
+func sessionTTL() time.Duration {
+	return authSettings.SessionTTL
+}
+
+// loginFailed audits and counts a failed login, and audits the lockout when the account gets locked
+func loginFailed(r *http.Request, email string) {
+	audit.Record(r, email, audit.ActionLogin, email, audit.OutcomeFailure)
+	if ratelimit.LoginFailed(email) {
+		logger.WarnContext(r.Context(), "Account locked after repeated failed logins", "email", email)
+		audit.Record(r, email, audit.ActionLockout, email, audit.OutcomeSuccess)
+	}
+}
+
+// allowEmail applies the per-IP and per-address limits of endpoints that send emails,
+// answering 429 itself when the request is over a limit
+func allowEmail(w http.ResponseWriter, r *http.Request, kind string, email string) bool {
+	if ok, retryAfter := ratelimit.Allow(kind+"-ip:"+ratelimit.ClientIP(r), emailLimitPerIP, emailWindow); !ok {
+		ratelimit.TooManyRequests(w, retryAfter)
+		return false
+	}
+	if ok, retryAfter := ratelimit.Allow(kind+"-email:"+strings.ToLower(email), emailLimitPerAddress, emailWindow); !ok {
+		ratelimit.TooManyRequests(w, retryAfter)
+		return false
+	}
+	return true
 }
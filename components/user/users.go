@@ -0,0 +1,112 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"GOLANG_SERVER/components/audit"
+	"GOLANG_SERVER/components/auth"
+	"GOLANG_SERVER/components/db"
+	"GOLANG_SERVER/components/response"
+)
+
+// Users lists the users an admin manages: their organization's, plus the unassigned ones for the operator
+func Users(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	users, err := db.GetUsers(auth.Organization(r))
+	if err != nil {
+		response.Err(w, r, err)
+		return
+	}
+
+	profiles := make([]Profile, len(users))
+	for i, user := range users {
+		profiles[i] = profileOf(user)
+	}
+	response.JSON(w, http.StatusOK, profiles)
+}
+
+// UserByEmail returns (GET) or deletes (DELETE) the user of /users/{email}
+func UserByEmail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	email := r.PathValue("email")
+	user, err := db.GetUserInOrganization(auth.Organization(r), email)
+	if err != nil {
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		response.JSON(w, http.StatusOK, profileOf(user))
+
+	case http.MethodDelete:
+		// * admins delete their own account via /me, which keeps the last admin from leaving
+		admin, _ := auth.UserFrom(r)
+		if admin.Email == email {
+			response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Use /me to delete your own account")
+			return
+		}
+
+		if _, err := db.DeleteUser(auth.Organization(r), email); err != nil {
+			audit.Record(r, admin.Email, audit.ActionUserDelete, email, audit.OutcomeFailure)
+			response.Err(w, r, err)
+			return
+		}
+
+		logger.InfoContext(r.Context(), "User deleted", "email", email)
+		audit.Record(r, admin.Email, audit.ActionUserDelete, email, audit.OutcomeSuccess)
+		reply := map[string]string{"message": "User deleted", "Email": email}
+		response.JSON(w, http.StatusOK, reply)
+
+	default:
+		response.MethodNotAllowed(w)
+	}
+}
+
+// SetDisabled lets an admin disable or re-enable a user of their organization
+func SetDisabled(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { // Allow only POST requests
+		response.MethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Email    string `json:"Email"`
+		Disabled bool   `json:"Disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, err)
+		return
+	}
+
+	admin, _ := auth.UserFrom(r)
+	if admin.Email == req.Email {
+		response.Error(w, http.StatusBadRequest, response.CodeBadRequest, "Admins can't disable themselves")
+		return
+	}
+
+	action := audit.ActionUserEnable
+	if req.Disabled {
+		action = audit.ActionUserDisable
+	}
+	if _, err := db.SetUserDisabled(auth.Organization(r), req.Email, req.Disabled); err != nil {
+		audit.Record(r, admin.Email, action, req.Email, audit.OutcomeFailure)
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "User disabled changed", "email", req.Email, "disabled", req.Disabled)
+	audit.Record(r, admin.Email, action, req.Email, audit.OutcomeSuccess)
+	reply := map[string]any{"message": "User updated", "Email": req.Email, "Disabled": req.Disabled}
+	response.JSON(w, http.StatusOK, reply)
+}
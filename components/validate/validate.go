@@ -0,0 +1,222 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"GOLANG_SERVER/components/logging"
+	schema "GOLANG_SERVER/components/schema"
+)
+
+// Range is the accepted [Min, Max] interval of a numeric field
+type Range struct {
+	Min float64 `json:"Min"`
+	Max float64 `json:"Max"`
+}
+
+// Rules describes what a reading must look like before it is stored.
+// Range keys are field paths such as "Temperature" or "X.VibrationSpeed";
+// an axis field without prefix (e.g. "VibrationSpeed") applies to X, Y and Z.
+type Rules struct {
+	Ranges       map[string]Range `json:"Ranges"`
+	Required     []string         `json:"Required"`
+	AllowUnknown bool             `json:"AllowUnknown"`
+}
+
+var axes = []string{"X", "Y", "Z"}
+
+var logger = logging.For("validate")
+
+// * active rules, replaced by LoadRules and SetRules
+var rules = DefaultRules()
+var rulesMutex sync.RWMutex
+
+// DefaultRules returns ranges that cover what the vibration sensors can physically report
+func DefaultRules() Rules {
+	return Rules{
+		Ranges: map[string]Range{
+			"Acceleration":                   {Min: -160, Max: 160},
+			"VelocityAngular":                {Min: -2000, Max: 2000},
+			"VibrationSpeed":                 {Min: 0, Max: 500},
+			"VibrationAngle":                 {Min: -180, Max: 360},
+			"VibrationDisplacement":          {Min: 0, Max: 30000},
+			"VibrationDisplacementHighSpeed": {Min: 0, Max: 30000},
+			"Frequency":                      {Min: 0, Max: 5000},
+			"Temperature":                    {Min: -40, Max: 125},
+		},
+		Required: []string{"DeviceAddress"},
+	}
+}
+
+// LoadRules reads the rules from a JSON file (VALIDATION_RULES), keeping the defaults if path is empty
+func LoadRules(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	loaded := DefaultRules()
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		return fmt.Errorf("invalid validation rules in %s: %w", path, err)
+	}
+	if err := SetRules(loaded); err != nil {
+		return fmt.Errorf("invalid validation rules in %s: %w", path, err)
+	}
+
+	logger.Info("Validation rules loaded", "path", path)
+	return nil
+}
+
+// SetRules replaces the rules in use after checking they only name known fields
+func SetRules(newRules Rules) error {
+	for field, limit := range newRules.Ranges {
+		if _, ok := fields(schema.GyroData{})[field]; !ok && !isAxisField(field) {
+			return fmt.Errorf("unknown field %q", field)
+		}
+		if limit.Min > limit.Max {
+			return fmt.Errorf("%s: Min is greater than Max", field)
+		}
+	}
+	for _, field := range newRules.Required {
+		if _, ok := fields(schema.GyroData{})[field]; !ok && field != "DeviceAddress" {
+			return fmt.Errorf("unknown required field %q", field)
+		}
+	}
+
+	rulesMutex.Lock()
+	defer rulesMutex.Unlock()
+	rules = newRules
+	return nil
+}
+
+// CurrentRules returns the rules in use
+func CurrentRules() Rules {
+	rulesMutex.RLock()
+	defer rulesMutex.RUnlock()
+	return rules
+}
+
+// Payload decodes a JSON reading and checks it against the rules
+func Payload(raw []byte) (schema.GyroData, error) {
+	var data schema.GyroData
+	rules := CurrentRules()
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if !rules.AllowUnknown {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(&data); err != nil {
+		return schema.GyroData{}, err
+	}
+
+	// * required fields must be present in the document, not just zero
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return schema.GyroData{}, err
+	}
+	var problems []string
+	for _, field := range rules.Required {
+		if !present(document, field) {
+			problems = append(problems, fmt.Sprintf("%s is required", field))
+		}
+	}
+	if len(problems) > 0 {
+		return schema.GyroData{}, errors.New(strings.Join(problems, "; "))
+	}
+
+	return data, Reading(data)
+}
+
+// Reading checks an already decoded reading against the rules
+func Reading(data schema.GyroData) error {
+	var problems []string
+	rules := CurrentRules()
+
+	for _, field := range rules.Required {
+		if field == "DeviceAddress" && strings.TrimSpace(data.DeviceAddress) == "" {
+			problems = append(problems, "DeviceAddress is empty")
+		}
+	}
+
+	values := fields(data)
+	for _, field := range sortedKeys(values) {
+		value := values[field]
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			problems = append(problems, fmt.Sprintf("%s is not a finite number", field))
+			continue
+		}
+
+		limit, ok := rules.Ranges[field]
+		if !ok {
+			limit, ok = rules.Ranges[field[strings.Index(field, ".")+1:]]
+		}
+		if ok && (value < limit.Min || value > limit.Max) {
+			problems = append(problems, fmt.Sprintf("%s=%g out of range [%g, %g]", field, value, limit.Min, limit.Max))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// fields flattens the numeric fields of a reading by path
+func fields(data schema.GyroData) map[string]float64 {
+	values := map[string]float64{"Temperature": float64(data.Temperature)}
+	for i, axis := range []schema.GyroStruct{data.X, data.Y, data.Z} {
+		prefix := axes[i] + "."
+		values[prefix+"Acceleration"] = float64(axis.Acceleration)
+		values[prefix+"VelocityAngular"] = float64(axis.VelocityAngular)
+		values[prefix+"VibrationSpeed"] = float64(axis.VibrationSpeed)
+		values[prefix+"VibrationAngle"] = float64(axis.VibrationAngle)
+		values[prefix+"VibrationDisplacement"] = float64(axis.VibrationDisplacement)
+		values[prefix+"VibrationDisplacementHighSpeed"] = float64(axis.VibrationDisplacementHighSpeed)
+		values[prefix+"Frequency"] = float64(axis.Frequency)
+	}
+	return values
+}
+
+func isAxisField(field string) bool {
+	_, ok := fields(schema.GyroData{})["X."+field]
+	return ok
+}
+
+// present reports whether a (possibly nested) field path exists in a JSON document
+func present(document map[string]json.RawMessage, path string) bool {
+	name, rest, nested := strings.Cut(path, ".")
+	for key, value := range document {
+		if !strings.EqualFold(key, name) {
+			continue
+		}
+		if !nested {
+			return string(value) != "null"
+		}
+		var child map[string]json.RawMessage
+		if err := json.Unmarshal(value, &child); err != nil {
+			return false
+		}
+		return present(child, rest)
+	}
+	return false
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,80 +1,340 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"strconv"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"GOLANG_SERVER/components/apidocs"
+	"GOLANG_SERVER/components/config"
 	"GOLANG_SERVER/components/db"
-	"GOLANG_SERVER/components/env"
+	"GOLANG_SERVER/components/events"
+	"GOLANG_SERVER/components/export"
+	"GOLANG_SERVER/components/health"
+	"GOLANG_SERVER/components/ingest"
+	"GOLANG_SERVER/components/logging"
+	"GOLANG_SERVER/components/metrics"
+	"GOLANG_SERVER/components/protocal/modbus"
 	"GOLANG_SERVER/components/protocal/mosquitto"
 	"GOLANG_SERVER/components/protocal/rest"
-	"GOLANG_SERVER/components/protocal/ws"
+	"GOLANG_SERVER/components/ratelimit"
+	"GOLANG_SERVER/components/router"
+	"GOLANG_SERVER/components/routes"
+	"GOLANG_SERVER/components/schema"
+	"GOLANG_SERVER/components/sso"
 	"GOLANG_SERVER/components/user"
+	"GOLANG_SERVER/components/validate"
 )
 
 // Main function
 func main() {
-	// Load environment variables
-	if err := env.LoadEnv(); err != nil {
-		log.Fatal("Error loading environment variables:", err)
+	// Load the configuration from the environment, .env and CONFIG_FILE
+	cfg, err := config.Load()
+
+	// * "config print" shows the effective configuration with secrets redacted, and what is wrong with it
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "print" {
+		if printErr := cfg.Print(); printErr != nil {
+			log.Fatal("Error printing configuration:", printErr)
+		}
+	}
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
 		return
 	}
 
-	// Get the port from the environment variables
-	port, err := strconv.Atoi(env.GetEnv("PORT"))
-	if err != nil {
-		log.Fatal("Invalid port number:", err)
+	// Every component logs through slog from here on
+	if err := logging.Setup(cfg.Log); err != nil {
+		log.Fatal("Error setting up logging: ", err)
 		return
 	}
+	logger := logging.For("server")
+
+	// Load the payload validation rules
+	if err := validate.LoadRules(cfg.Ingest.ValidationRules); err != nil {
+		logger.Error("Error loading validation rules", "error", err)
+		os.Exit(1)
+	}
+
+	// Hand every component its settings
+	ratelimit.SetTrustProxy(cfg.TrustProxy)
+	rest.Configure(cfg.CleanPassword, cfg.Ingest.BulkBatchSize)
+	user.Configure(cfg.Auth, cfg.SMTP, cfg.OIDC)
 
 	// Connect to the database
-	if _, err := db.Connect(); err == nil {
+	if _, err := db.Connect(cfg.Mongo); err == nil {
+		// * "archive" writes readings into Parquet files per device and day, and prunes them with -prune
+		if len(os.Args) > 1 && os.Args[1] == "archive" {
+			if err := archive(os.Args[2:]); err != nil {
+				logger.Error("Error archiving readings", "error", err)
+				os.Exit(1)
+			}
+			return
+		}
+		// * "promote" makes a registered user an admin, e.g. the operator of a new deployment
+		if len(os.Args) > 1 && os.Args[1] == "promote" {
+			if err := promote(os.Args[2:]); err != nil {
+				logger.Error("Error promoting user", "error", err)
+				os.Exit(1)
+			}
+			return
+		}
+		// * "import" loads historical readings from CSV or NDJSON files, keeping their own time
+		if len(os.Args) > 1 && os.Args[1] == "import" {
+			if err := importFiles(os.Args[2:], cfg.Ingest.BulkBatchSize); err != nil {
+				logger.Error("Error importing readings", "error", err)
+				os.Exit(1)
+			}
+			return
+		}
+
 		// Welcome message
-		fmt.Println("Message:", env.GetEnv("MESSAGE"))
+		logger.Info("Welcome", "message", cfg.Message)
+		if admins, err := db.CountAdmins(schema.DefaultOrganization); err == nil && admins == 0 {
+			logger.Warn("The default organization has no admin, register an account and run: promote -email <address>")
+		}
+
+		//? Start the mock identity provider (development only) and set up single sign-on
+		if cfg.OIDC.MockProvider != "" {
+			if _, err := sso.StartMockProvider(cfg.OIDC); err != nil {
+				logger.Error("Error starting mock OIDC provider", "error", err)
+			}
+		}
+		if err := sso.Setup(cfg.OIDC); err != nil {
+			logger.Error("Error setting up single sign-on", "error", err)
+			os.Exit(1)
+		}
+
+		//? Dependencies checked by /readyz, the server isn't ready while a critical one is down
+		health.Register("mongo", true, db.HealthCheck)
+		health.Register("mqtt", true, mosquitto.HealthCheck)
+		health.Register("ingest", false, ingest.HealthCheck)
+		metrics.QueueDepth(ingest.QueueDepth)
 
 		//TODO REST API route
-		http.HandleFunc("/api", rest.HandleAPI)
-		http.HandleFunc("/data", rest.HandleGetAllData)
-		http.HandleFunc("/store", rest.HandleStore)
-		http.HandleFunc("/latest", rest.HandleGetLatestData)
-		http.HandleFunc("/clean", rest.HandleCleanData)
-
-		http.HandleFunc("/registerdevice", rest.HandleRegisterDevice)                         //*DONE Register device
-		http.HandleFunc("/deviceaddresses", rest.HandleGetDeviceAddress)                      //*DONE Get device address
-		http.HandleFunc("/checkdeviceaddresses/", rest.HandleGetDeviceAddressByDeviceAddress) //*DONE Get device address by device address
-		http.HandleFunc("/data/", rest.HandleGetAllDataByDeviceAddress)                       //*DONE Get data use param
-		http.HandleFunc("/register", user.Register)                                           //*DONE Register user by Enail and Password
-		http.HandleFunc("/login", user.Login)                                                 //*DONE login user by Email and Password
-		http.HandleFunc("/sendotp", user.SendOTP)                                             //*DONE Send OTP to Email
-
-		// TODO: WebSocket route
-		http.HandleFunc("/ws", ws.HandleWebSocket)           //*DONE Handle WebSocket connection
-		http.HandleFunc("/storews", ws.HandleStoreWebSocket) //*DONE Store data from websocket
+		api := routes.API()
+
+		// * the apidocs tests keep the document in line with the routes, a mismatch only costs the docs
+		if err := apidocs.Setup(api.Routes()); err != nil {
+			logger.Error("API documentation does not match the routes", "error", err)
+		}
+
+		// * outermost first: request IDs and logging, then panics, CORS and compression
+		handler := router.Chain(api,
+			logging.Middleware,
+			router.Recover,
+			router.CORS(splitList(cfg.CORSOrigins)),
+			router.Compress,
+		)
 
 		// TODO: Start the server in a goroutine
 		go func() {
-			fmt.Println("Server started at Gyro Server.")
-			if err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil); err != nil {
-				log.Fatal("Error starting server:", err)
+			logger.Info("Server started at Gyro Server", "port", cfg.Port)
+			if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), handler); err != nil {
+				logger.Error("Error starting server", "error", err)
+				os.Exit(1)
 			}
 		}()
 
+		// * the metrics name every device of every organization, they are served on their own port for Prometheus only
+		if cfg.MetricsPort != 0 {
+			go func() {
+				metricsMux := http.NewServeMux()
+				metricsMux.Handle("GET /metrics", metrics.Handler())
+				logger.Info("Metrics served", "port", cfg.MetricsPort)
+				if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.MetricsPort), metricsMux); err != nil {
+					logger.Error("Error serving metrics", "error", err)
+					os.Exit(1)
+				}
+			}()
+		}
+
+		// * report devices going offline on /stream
+		go events.Watch(context.Background(), cfg.Ingest.OfflineAfter)
+
 		//? Start MQTT client
-		go mosquitto.HandleMQTT()
+		go mosquitto.HandleMQTT(cfg.MQTT)
+
+		//? Start the Modbus simulator (development only) and the Modbus poller
+		if cfg.Modbus.Simulator != "" {
+			if err := modbus.NewSimulator(cfg.Modbus.Simulator).Start(); err != nil {
+				logger.Error("Error starting modbus simulator", "error", err)
+			}
+		}
+		modbus.HandleModbus(cfg.Modbus.Config)
 
 		// Wait for 'q' or 'Q' to stop the server
 		var input string
 		for {
 			fmt.Scanln(&input)
 			if input == "q" || input == "Q" {
-				fmt.Println("Server stopping...")
+				logger.Info("Server stopping")
 				break // Stop the server
 			}
 		}
 	} else {
-		fmt.Println("Error connecting to database something went wrong!!")
+		logger.Error("Error connecting to database, stopping")
 		return
 	}
 }
+
+// splitList splits a comma separated setting, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// archive parses the flags of the archive command and runs it. Only complete days are archived
+// by default, readings of today are still coming in.
+func archive(args []string) error {
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory to write the Parquet files into (required)")
+	organization := flags.String("organization", "", "organization slug, every organization if empty")
+	device := flags.String("device", "", "device address, every device if empty")
+	from := flags.String("from", "", "first day to archive, YYYY-MM-DD")
+	before := flags.String("before", "", "day to stop before, YYYY-MM-DD, today if empty")
+	olderThan := flags.Int("older-than", 0, "only archive days at least this many days ago")
+	prune := flags.Bool("prune", false, "delete the archived readings from the database")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+
+	options := export.ArchiveOptions{Dir: *dir, Organization: *organization, Device: *device, Prune: *prune}
+	options.Before = time.Now().UTC().Truncate(24 * time.Hour)
+	if *before != "" {
+		day, err := time.Parse(time.DateOnly, *before)
+		if err != nil {
+			return fmt.Errorf("-before: %w", err)
+		}
+		options.Before = day
+	}
+	if *olderThan > 0 {
+		if limit := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -*olderThan+1); limit.Before(options.Before) {
+			options.Before = limit
+		}
+	}
+	if *from != "" {
+		day, err := time.Parse(time.DateOnly, *from)
+		if err != nil {
+			return fmt.Errorf("-from: %w", err)
+		}
+		options.From = day
+	}
+	return export.Archive(context.Background(), options)
+}
+
+// promote parses the flags of the promote command and makes the user an admin of the organization
+func promote(args []string) error {
+	flags := flag.NewFlagSet("promote", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the registered user (required)")
+	organization := flags.String("organization", schema.DefaultOrganization, "organization slug the user administers")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return fmt.Errorf("-email is required")
+	}
+
+	if _, err := db.SetUserOrganization(*email, *organization); err != nil {
+		return err
+	}
+	if _, err := db.SetUserRole(*organization, *email, schema.RoleAdmin); err != nil {
+		return err
+	}
+	logging.For("promote").Info("User promoted to admin", "email", *email, "organization", *organization)
+	return nil
+}
+
+// importFiles parses the flags of the import command and imports every file named after them
+func importFiles(args []string, batchSize int) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "csv or ndjson, from the file extension if empty")
+	mapping := flags.String("map", "", "CSV columns to reading fields, e.g. ts=TimeStamp,ax=X.Acceleration")
+	device := flags.String("device", "", "device of CSV rows without a DeviceAddress")
+	zone := flags.String("tz", "UTC", "time zone of DateTime values without an offset")
+	delimiter := flags.String("delimiter", ",", "CSV column delimiter")
+	organization := flags.String("organization", "", "organization the devices must be registered to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("name the files to import")
+	}
+
+	options := ingest.ImportOptions{Device: *device, Organization: *organization, BatchSize: batchSize}
+	var err error
+	if options.Mapping, err = ingest.ParseMapping(*mapping); err != nil {
+		return err
+	}
+	if options.Location, err = time.LoadLocation(*zone); err != nil {
+		return err
+	}
+	if options.Delimiter, _ = utf8.DecodeRuneInString(*delimiter); utf8.RuneCountInString(*delimiter) != 1 {
+		return fmt.Errorf("-delimiter must be one character")
+	}
+
+	logger := logging.For("import")
+	failed := false
+	for _, name := range flags.Args() {
+		options.Format = *format
+		if options.Format == "" {
+			options.Format = ingest.ImportFormatOf(name)
+		}
+		options.Progress = func(report ingest.ImportReport) {
+			logger.Info("Importing", "file", name, "received", report.Received, "stored", report.Stored)
+		}
+
+		report, err := importFile(name, options)
+		logger.Info("File imported", "file", name, "received", report.Received, "stored", report.Stored,
+			"duplicates", report.Duplicates, "rejected", report.Rejected, "failed", report.Failed, "ignored_columns", report.IgnoredColumns)
+		for _, problem := range report.Errors {
+			logger.Warn("Reading not imported", "file", name, "line", problem.Line, "error", problem.Error)
+		}
+		if err != nil {
+			logger.Error("Error importing file", "file", name, "error", err)
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("not every file could be imported")
+	}
+	return nil
+}
+
+// importFile imports one file, gunzipping it if its name ends in .gz
+func importFile(name string, options ingest.ImportOptions) (ingest.ImportReport, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return ingest.ImportReport{}, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if ingest.Compressed(name) {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return ingest.ImportReport{}, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	return ingest.Import(context.Background(), reader, options)
+}